@@ -0,0 +1,156 @@
+package boosting
+
+import (
+	"fmt"
+	"math"
+
+	"robertkotcher.me/ML2022/dataset"
+	"robertkotcher.me/ML2022/decision_tree"
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+// defaultStumpDepth is used when TreeOptions doesn't set a MaxDepth. A tree with depth 1 is
+// just the root, so a stump (a single split) has a depth of 2.
+const defaultStumpDepth = 2
+
+// BuildAdaBoostModel builds a weighted vote model with AdaBoost, using the SAMME algorithm so
+// that targets can have any number of classes. Each round trains a stump on the weighted rows,
+// gives it a vote of learning rate * (log((1 - err) / err) + log(K - 1)), and then increases
+// the weight of the rows that it misclassified.
+//
+// Stumps are built with options.Evaluator, which defaults to a ClassificationEvaluator, and
+// options.TreeOptions. A LearningRate of 1 gives standard SAMME, and is used when the rate isn't
+// positive.
+//
+// The second return value holds the weighted training error of the stump built on each round.
+// Training stops early if a stump classifies every row correctly, or if it does no better than
+// guessing.
+func BuildAdaBoostModel(ds *dataset.Dataset, options BuildOptions) (*BoostingModel, *[]float64, error) {
+	numClasses, err := countClasses(ds)
+	if err != nil {
+		return nil, nil, err
+	}
+	if numClasses < 2 {
+		return nil, nil, fmt.Errorf("AdaBoost needs at least two target classes, found %d", numClasses)
+	}
+
+	learningRate := options.LearningRate
+	if learningRate <= 0 {
+		learningRate = 1
+	}
+
+	model := BoostingModel{
+		Successors:     &[]decision_tree.DecisionNode{},
+		LearningRate:   learningRate,
+		Objective:      WeightedVote,
+		LearnerWeights: &[]float64{},
+		NumClasses:     numClasses,
+	}
+	roundErrors := []float64{}
+
+	stumpEvaluator := options.Evaluator
+	if stumpEvaluator == nil {
+		stumpEvaluator = decision_tree.ClassificationEvaluator{}
+	}
+	stumpOptions := options.TreeOptions
+	if stumpOptions.MaxDepth == nil {
+		stumpOptions.MaxDepth = ptr.PointToInt(defaultStumpDepth)
+	}
+
+	// every row starts out with the same weight
+	weights := make([]float64, ds.Size())
+	for r := range weights {
+		weights[r] = 1.0 / float64(ds.Size())
+	}
+
+	for i := 0; i < options.NumIterations; i++ {
+		weighted, err := ds.WithWeights(weights)
+		if err != nil {
+			return nil, nil, err
+		}
+		stump, err := decision_tree.BuildTreeWithOverfitting(weighted, stumpEvaluator, stumpOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		// every row is predicted by every stump, so don't recount a leaf's classes each time
+		stump.CacheLeafValues()
+
+		// find the weighted error, keeping track of which rows this stump got wrong
+		misclassified := make([]bool, ds.Size())
+		stumpErr := 0.0
+		totalWeight := 0.0
+		for r, row := range ds.Rows {
+			pred, err := stump.Predict(row.X())
+			if err != nil {
+				return nil, nil, err
+			}
+			if *pred != row.Y() {
+				misclassified[r] = true
+				stumpErr += weights[r]
+			}
+			totalWeight += weights[r]
+		}
+		stumpErr /= totalWeight
+		roundErrors = append(roundErrors, stumpErr)
+
+		// a perfect stump outvotes every stump before it, since nothing could improve on it
+		if stumpErr <= 0 {
+			learnerWeight := 1.0
+			for _, w := range *model.LearnerWeights {
+				learnerWeight += w
+			}
+			*model.Successors = append(*model.Successors, *stump)
+			*model.LearnerWeights = append(*model.LearnerWeights, learnerWeight)
+			break
+		}
+
+		// a stump that's no better than guessing can't be reweighted into a useful one
+		if stumpErr >= 1-(1/float64(numClasses)) {
+			if len(*model.Successors) == 0 {
+				return nil, nil, fmt.Errorf("the first stump was no better than guessing (error %v)", stumpErr)
+			}
+			break
+		}
+
+		learnerWeight := model.LearningRate * (math.Log((1-stumpErr)/stumpErr) + math.Log(float64(numClasses-1)))
+		*model.Successors = append(*model.Successors, *stump)
+		*model.LearnerWeights = append(*model.LearnerWeights, learnerWeight)
+
+		// boost the weight of each misclassified row, and renormalize
+		totalWeight = 0.0
+		for r := range weights {
+			if misclassified[r] {
+				weights[r] *= math.Exp(learnerWeight)
+			}
+			totalWeight += weights[r]
+		}
+		for r := range weights {
+			weights[r] /= totalWeight
+		}
+	}
+
+	return &model, &roundErrors, nil
+}
+
+// voteShares returns the share of the total successor vote that went to each class
+func (m BoostingModel) voteShares(row dataset.Row) (*[]float64, error) {
+	if m.Successors == nil || len(*m.Successors) == 0 {
+		return nil, fmt.Errorf("cannot predict with a weighted vote model that has no successors")
+	}
+
+	votes := make([]float64, m.NumClasses)
+	totalVote := 0.0
+	for i, s := range *m.Successors {
+		pred, err := s.Predict(row)
+		if err != nil {
+			return nil, err
+		}
+		votes[int(*pred)] += (*m.LearnerWeights)[i]
+		totalVote += (*m.LearnerWeights)[i]
+	}
+
+	for k := range votes {
+		votes[k] /= totalVote
+	}
+	return &votes, nil
+}
@@ -1,57 +1,626 @@
 package boosting
 
 import (
+	"fmt"
+	"math"
+
 	"robertkotcher.me/ML2022/dataset"
 	"robertkotcher.me/ML2022/decision_tree"
 	ptr "robertkotcher.me/ML2022/util"
 )
 
+// defaultSuccessorDepth is used when TreeOptions doesn't set a MaxDepth. Successors are
+// weak learners, so they're kept shallow (3 levels of splits below the root).
+const defaultSuccessorDepth = 4
+
+// minHessian keeps Newton steps from dividing by zero when every row in a leaf has
+// already been classified with (near) certainty
+const minHessian = 1e-12
+
+// Objective describes what a BoostingModel's raw output means
+type Objective int
+
+const (
+	// Regression models minimize squared error and predict the target directly
+	Regression Objective = iota
+	// BinaryClassification models minimize log-loss. The raw output is log-odds of the
+	// positive class (enum value 1), and Predict returns its probability.
+	BinaryClassification
+	// MulticlassClassification models minimize softmax cross-entropy. Each class has its own
+	// model in ClassModels whose raw output is that class's (unnormalized) log-probability.
+	MulticlassClassification
+	// WeightedVote models predict the class with the most votes, where each successor votes for
+	// the class it predicts with the weight in LearnerWeights. These are built by AdaBoost.
+	WeightedVote
+)
+
 // Options effect trees
 type BuildOptions struct {
+	// Evaluator is used to build each successor tree. Successors are always fit to continuous
+	// pseudo-residuals, so this defaults to a RegressionEvaluator when nil. A SecondOrderEvaluator
+	// builds regularized successors from the gradient and hessian of the loss instead.
 	Evaluator     decision_tree.Evaluator
 	LearningRate  float64
 	NumIterations int
+	// TreeOptions are passed along when building each successor tree
+	TreeOptions decision_tree.BuildOptions
+	// Validation, when set, is scored with Metric after every iteration, and the scores are kept
+	// in the model's History. If Patience is positive and the validation score hasn't improved
+	// for Patience iterations, training stops early and the model is truncated to the iteration
+	// with the best validation score. Metric defaults to CrossEntropy for classifiers and
+	// MeanSquaredError otherwise.
+	Validation *dataset.Dataset
+	Metric     Metric
+	Patience   int
+	// Subsample is the fraction of rows that each successor is trained on, drawn without
+	// replacement. ColSampleByTree is the fraction of feature columns that each successor may
+	// split on, and ColSampleByNode is the fraction of those that each of its nodes considers.
+	// Zero means no sampling. Samples are drawn from a random source seeded with Seed, so builds
+	// with the same seed are reproducible.
+	Subsample       float64
+	ColSampleByTree float64
+	ColSampleByNode float64
+	Seed            int64
+	// Loss is the loss function minimized by gradient boosting. When nil, it's chosen from the
+	// evaluator passed to BuildGradiantBoostingModel: LogLoss for a ClassificationEvaluator and
+	// SquaredError otherwise. Multiclass models always use softmax cross-entropy.
+	Loss Loss
+	// DropRate turns on DART, which leaves a random subset of the existing successors out while
+	// fitting each new one, so that later successors don't just make small corrections to the
+	// first few. Each successor is dropped with probability DropRate, and SkipDrop is the
+	// probability that an iteration drops nothing at all. Afterwards, the new successor and the
+	// dropped ones are rescaled so that the model's predictions stay on the same scale. Zero turns
+	// DART off. Drops are drawn from the same random source as Subsample.
+	DropRate float64
+	SkipDrop float64
 }
 
 // BoostingModel is a struct that should be generic enough to fit both gradient and ADA boosting models.
 // Contains a root node and then subsequent nodes constructed with BuildOptions
 type BoostingModel struct {
-	Root       *decision_tree.DecisionNode
-	Successors *[]decision_tree.DecisionNode
+	Root         *decision_tree.DecisionNode
+	Successors   *[]decision_tree.DecisionNode
+	LearningRate float64
+	Objective    Objective
+	// Loss is the loss this model was trained on. Predict uses it to transform raw predictions.
+	Loss Loss
+	// History holds the training and validation scores of each iteration, and is only set when
+	// the model was built with a validation set
+	History *History
+	// ClassModels is only set for multiclass models, and holds one model per target class.
+	// Root and Successors are unused in that case.
+	ClassModels *[]BoostingModel
+	// LearnerWeights holds the weight of each successor's vote for weighted vote models, where
+	// Root is unused. Models built with DART also set it, and scale each successor's prediction
+	// by its weight. NumClasses is only set for weighted vote models.
+	LearnerWeights *[]float64
+	NumClasses     int
 }
 
-// Predict traverses the decisiontrees in this BoostingModel and returns prediction.
+// Predict traverses the decisiontrees in this BoostingModel and returns prediction. This is the root's
+// prediction plus the sum of all successor predictions, scaled by the learning rate, and transformed
+// by the model's loss. For binary classification, it's the probability that row belongs to the
+// positive class.
+// Weighted vote models return the winning class.
 func (m BoostingModel) Predict(row dataset.Row) (*float64, error) {
-	//
-	// TODO: implement me
-	//
-	return nil, nil
+	if m.Objective == MulticlassClassification {
+		return nil, fmt.Errorf("multiclass models predict one probability per class, use PredictProba")
+	}
+	if m.Objective == WeightedVote {
+		return m.PredictClass(row)
+	}
+
+	raw, err := m.rawPredict(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Loss != nil {
+		out := m.Loss.Transform(*raw)
+		return &out, nil
+	}
+	return raw, nil
+}
+
+// PredictClass returns the class predicted for row by a classification model. Binary models
+// predict the positive class (1) if its probability is at least 0.5 and the negative class (0)
+// otherwise. Multiclass models predict the class with the largest probability.
+func (m BoostingModel) PredictClass(row dataset.Row) (*float64, error) {
+	var prediction []float64
+	if m.Objective == BinaryClassification {
+		p, err := m.Predict(row)
+		if err != nil {
+			return nil, err
+		}
+		prediction = []float64{*p}
+	} else {
+		probabilities, err := m.PredictProba(row)
+		if err != nil {
+			return nil, err
+		}
+		prediction = *probabilities
+	}
+
+	class := predictedClass(prediction)
+	return &class, nil
+}
+
+// PredictProba returns the probability of each class for row, indexed by the class' enum value.
+// For weighted vote models, this is the share of the total vote that went to each class.
+func (m BoostingModel) PredictProba(row dataset.Row) (*[]float64, error) {
+	switch m.Objective {
+	case WeightedVote:
+		return m.voteShares(row)
+	case BinaryClassification:
+		p, err := m.Predict(row)
+		if err != nil {
+			return nil, err
+		}
+		return &[]float64{1 - *p, *p}, nil
+	case MulticlassClassification:
+		raw := make([]float64, len(*m.ClassModels))
+		for k, classModel := range *m.ClassModels {
+			out, err := classModel.rawPredict(row)
+			if err != nil {
+				return nil, err
+			}
+			raw[k] = *out
+		}
+		probabilities := softmax(raw)
+		return &probabilities, nil
+	}
+	return nil, fmt.Errorf("cannot predict class probabilities with a regression model")
+}
+
+// rawPredict returns the root's prediction plus the learning-rate-scaled (and weighted, for DART)
+// sum of successor predictions, before any transformation by the objective
+func (m BoostingModel) rawPredict(row dataset.Row) (*float64, error) {
+	stages, err := m.stagedRaw(row)
+	if err != nil {
+		return nil, err
+	}
+	return &stages[len(stages)-1], nil
 }
 
 // BuildGradiantBoostingModel returns a pointer to BoostingModel. It uses 'evaluator' to determine whether this is boosting or regression.
 // The parameter 'options' contains parameters that are specific to the gradient boosting algorithm.
+//
+// Passing a ClassificationEvaluator builds a classifier, and the target column must be categorical.
+// Targets with two classes (enum values 0 and 1) build a binary classifier on log-loss, and targets
+// with more classes build a multiclass classifier on softmax cross-entropy. Otherwise, the model
+// minimizes options.Loss, or squared error if that isn't set.
 func BuildGradiantBoostingModel(ds *dataset.Dataset, evaluator decision_tree.Evaluator, options BuildOptions) (*BoostingModel, error) {
-	model := BoostingModel{}
+	model := BoostingModel{
+		Successors:   &[]decision_tree.DecisionNode{},
+		LearningRate: options.LearningRate,
+		Loss:         options.Loss,
+	}
+
+	if _, ok := evaluator.(decision_tree.ClassificationEvaluator); ok {
+		numClasses, err := countClasses(ds)
+		if err != nil {
+			return nil, err
+		}
+		if numClasses > 2 {
+			return buildMulticlassModel(ds, evaluator, options, numClasses)
+		}
+		if model.Loss == nil {
+			model.Loss = LogLoss{}
+		}
+	}
+	if model.Loss == nil {
+		model.Loss = SquaredError{}
+	}
+	if _, ok := model.Loss.(LogLoss); ok {
+		numClasses, err := countClasses(ds)
+		if err != nil {
+			return nil, err
+		}
+		if numClasses > 2 {
+			return nil, fmt.Errorf("log-loss expects two target classes, found %d", numClasses)
+		}
+		model.Objective = BinaryClassification
+	}
+
+	targets := targetsOf(ds)
 
 	rootOptions := decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(1)}
-	root, err := decision_tree.BuildTreeWithOverfitting(ds, options.Evaluator, rootOptions)
+	root, err := decision_tree.BuildTreeWithOverfitting(ds, evaluator, rootOptions)
 	if err != nil {
 		return nil, err
 	}
 
 	// the root is the prediction returned by a single DT, built from 'evaluator', with a depth of 1.
+	// its value is the constant that minimizes the loss, e.g. the average for squared error or the
+	// log-odds of the positive class for log-loss.
+	initial := model.Loss.InitialPrediction(targets)
+	root.Value = &initial
 	model.Root = root
 
+	successorEvaluator, successorOptions := successorSettings(options)
+	sampler := newSampler(ds, options)
+	dart := newDropout(options, sampler.rng)
+
+	// keep track of the model's current (raw) prediction for each row (and each validation row)
+	output := newTrackedOutput(initial, ds, options.Validation, model.LearningRate, dart != nil)
+
+	var stopper *earlyStopper
+	var bestWeights []float64
+	if options.Validation != nil {
+		stopper = newEarlyStopper(ds, options, model.Objective)
+		stopper.record(model.outputs(output.train), model.outputs(output.valid))
+	}
+
 	for i := 0; i < options.NumIterations; i++ {
-		// 1 clone dataset
+		// DART leaves some of the existing successors out while fitting this iteration
+		dropped := dart.drop(len(*model.Successors))
+		predictions := output.withoutDropped(dropped)
 
-		// 2 fit the target column to equal pseudo-residual (model.Predict - row.Y)
+		// fit the target column to equal pseudo-residual, i.e. the negative gradient of the loss.
+		// for squared error this is (row.Y - model.Predict), and for log-loss (row.Y - p)
+		gradients := make([]float64, ds.Size())
+		hessians := make([]float64, ds.Size())
+		for r, row := range ds.Rows {
+			gradients[r] = model.Loss.Gradient(row.Y(), predictions[r])
+			hessians[r] = model.Loss.Hessian(row.Y(), predictions[r])
+		}
 
-		// 3 build a new decision tree on this dataset
-		//   on first iteration, root prediction plus this tree's prediction would give us exact target
+		// build a new decision tree on this dataset (or a sample of it). on first iteration, root
+		// prediction plus this tree's prediction would give us (roughly) the exact target.
+		//
+		// the tree only tells us how to group rows. each leaf's value is whatever minimizes the
+		// loss for the rows in it, e.g. the average residual for squared error, or a Newton step
+		// for log-loss
+		rows := sampler.rows()
+		successor, err := fitSuccessor(ds, rows, gradients, hessians, successorEvaluator, sampler.treeOptions(successorOptions), func(rows []int) float64 {
+			actual := make([]float64, len(rows))
+			raw := make([]float64, len(rows))
+			for i, r := range rows {
+				actual[i] = targets[r]
+				raw[i] = predictions[r]
+			}
+			return model.Loss.LeafValue(actual, raw)
+		})
+		if err != nil {
+			return nil, err
+		}
+		*model.Successors = append(*model.Successors, *successor)
 
-		// 4 (now this model's prediction would be root prediction plus residual * learning rate)
+		// now this model's prediction is the previous prediction plus residual * learning rate
+		weight, dropScale := dart.weights(len(dropped), model.LearningRate)
+		err = output.add(successor, ds, options.Validation, weight, dropped, dropScale)
+		if err != nil {
+			return nil, err
+		}
+
+		if stopper != nil {
+			stop := stopper.record(model.outputs(output.train), model.outputs(output.valid))
+			if stopper.improved() {
+				bestWeights = append([]float64{}, output.weights...)
+			}
+			if stop {
+				break
+			}
+		}
+	}
+
+	if dart != nil {
+		model.LearnerWeights = &output.weights
+	}
+
+	if stopper != nil {
+		model.History = &stopper.history
+		if options.Patience > 0 {
+			*model.Successors = (*model.Successors)[:model.History.BestIteration]
+			if dart != nil {
+				model.LearnerWeights = &bestWeights
+			}
+		}
 	}
 
 	return &model, nil
 }
+
+// buildMulticlassModel builds one model per class, adding a tree to each of them on every
+// iteration. Trees are fit to the negative gradient of softmax cross-entropy, y_k - p_k.
+func buildMulticlassModel(ds *dataset.Dataset, evaluator decision_tree.Evaluator, options BuildOptions, numClasses int) (*BoostingModel, error) {
+	model := BoostingModel{
+		LearningRate: options.LearningRate,
+		Objective:    MulticlassClassification,
+		ClassModels:  &[]BoostingModel{},
+	}
+
+	// each class starts out predicting the log of its prior probability
+	priors := make([]float64, numClasses)
+	for _, row := range ds.Rows {
+		priors[int(row.Y())] += 1.0 / float64(ds.Size())
+	}
+
+	rootOptions := decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(1)}
+	for k := 0; k < numClasses; k++ {
+		root, err := decision_tree.BuildTreeWithOverfitting(ds, evaluator, rootOptions)
+		if err != nil {
+			return nil, err
+		}
+		logPrior := math.Log(math.Max(priors[k], minHessian))
+		root.Value = &logPrior
+
+		*model.ClassModels = append(*model.ClassModels, BoostingModel{
+			Root:         root,
+			Successors:   &[]decision_tree.DecisionNode{},
+			LearningRate: options.LearningRate,
+		})
+	}
+
+	successorEvaluator, successorOptions := successorSettings(options)
+	sampler := newSampler(ds, options)
+	dart := newDropout(options, sampler.rng)
+
+	// keep track of each class' current (raw) prediction for each row (and each validation row)
+	outputs := make([]*trackedOutput, numClasses)
+	for k, classModel := range *model.ClassModels {
+		outputs[k] = newTrackedOutput(*classModel.Root.Value, ds, options.Validation, model.LearningRate, dart != nil)
+	}
+
+	var stopper *earlyStopper
+	var bestWeights []float64
+	if options.Validation != nil {
+		stopper = newEarlyStopper(ds, options, model.Objective)
+		stopper.record(classProbabilities(outputs, false), classProbabilities(outputs, true))
+	}
+
+	for i := 0; i < options.NumIterations; i++ {
+		// DART leaves the same successors out of every class while fitting this iteration
+		dropped := dart.drop(i)
+
+		// every class' tree in this iteration is fit against the same probabilities
+		predictions := make([][]float64, numClasses)
+		for k, output := range outputs {
+			predictions[k] = output.withoutDropped(dropped)
+		}
+		probabilities := make([][]float64, ds.Size())
+		for r := range ds.Rows {
+			raw := make([]float64, numClasses)
+			for k := range raw {
+				raw[k] = predictions[k][r]
+			}
+			probabilities[r] = softmax(raw)
+		}
+
+		// every class' tree in this iteration is also fit against the same rows
+		rows := sampler.rows()
+		weight, dropScale := dart.weights(len(dropped), model.LearningRate)
+
+		for k := 0; k < numClasses; k++ {
+			gradients := make([]float64, ds.Size())
+			hessians := make([]float64, ds.Size())
+			for r, row := range ds.Rows {
+				gradients[r] = probabilities[r][k]
+				if int(row.Y()) == k {
+					gradients[r] -= 1
+				}
+				hessians[r] = probabilities[r][k] * (1 - probabilities[r][k])
+			}
+
+			// Friedman's Newton step for softmax leaves:
+			// (K - 1) / K * sum(residual) / sum(|residual| * (1 - |residual|))
+			successor, err := fitSuccessor(ds, rows, gradients, hessians, successorEvaluator, sampler.treeOptions(successorOptions), func(rows []int) float64 {
+				numerator, denominator := 0.0, 0.0
+				for _, r := range rows {
+					numerator -= gradients[r]
+					denominator += math.Abs(gradients[r]) * (1 - math.Abs(gradients[r]))
+				}
+				scale := float64(numClasses-1) / float64(numClasses)
+				return scale * numerator / math.Max(denominator, minHessian)
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			classModel := (*model.ClassModels)[k]
+			*classModel.Successors = append(*classModel.Successors, *successor)
+		}
+
+		// only update predictions once every class' tree has been fit against the old ones
+		for k, output := range outputs {
+			classModel := (*model.ClassModels)[k]
+			successor := &(*classModel.Successors)[i]
+			err := output.add(successor, ds, options.Validation, weight, dropped, dropScale)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if stopper != nil {
+			stop := stopper.record(classProbabilities(outputs, false), classProbabilities(outputs, true))
+			if stopper.improved() {
+				// DART weights are the same for every class
+				bestWeights = append([]float64{}, outputs[0].weights...)
+			}
+			if stop {
+				break
+			}
+		}
+	}
+
+	for k := range *model.ClassModels {
+		classModel := &(*model.ClassModels)[k]
+		if dart != nil {
+			classModel.LearnerWeights = &outputs[k].weights
+		}
+		if stopper != nil && options.Patience > 0 {
+			*classModel.Successors = (*classModel.Successors)[:stopper.history.BestIteration]
+			if dart != nil {
+				weights := append([]float64{}, bestWeights...)
+				classModel.LearnerWeights = &weights
+			}
+		}
+	}
+	if stopper != nil {
+		model.History = &stopper.history
+	}
+
+	return &model, nil
+}
+
+// outputs transforms raw predictions into the form expected by a Metric
+func (m BoostingModel) outputs(raw []float64) [][]float64 {
+	out := make([][]float64, len(raw))
+	for r := range raw {
+		out[r] = []float64{m.Loss.Transform(raw[r])}
+	}
+	return out
+}
+
+// classProbabilities turns the raw per-class predictions of each training (or validation) row
+// into probabilities
+func classProbabilities(outputs []*trackedOutput, validation bool) [][]float64 {
+	numRows := len(outputs[0].train)
+	if validation {
+		numRows = len(outputs[0].valid)
+	}
+
+	out := make([][]float64, numRows)
+	for r := range out {
+		raw := make([]float64, len(outputs))
+		for k, output := range outputs {
+			if validation {
+				raw[k] = output.valid[r]
+			} else {
+				raw[k] = output.train[r]
+			}
+		}
+		out[r] = softmax(raw)
+	}
+	return out
+}
+
+// successorSettings returns the evaluator and options used to build each successor tree,
+// filling in defaults where options doesn't specify them
+func successorSettings(options BuildOptions) (decision_tree.Evaluator, decision_tree.BuildOptions) {
+	successorEvaluator := options.Evaluator
+	if successorEvaluator == nil {
+		successorEvaluator = decision_tree.RegressionEvaluator{}
+	}
+	successorOptions := options.TreeOptions
+	if successorOptions.MaxDepth == nil {
+		successorOptions.MaxDepth = ptr.PointToInt(defaultSuccessorDepth)
+	}
+	return successorEvaluator, successorOptions
+}
+
+// fitSuccessor builds the tree for a single boosting iteration from the rows of ds at the provided
+// indices. gradients and hessians hold the derivatives of the loss for every row of ds.
+//
+// A SecondOrderEvaluator builds a regularized tree straight from the gradients and hessians, and
+// its leaves keep their own (regularized) weights. Any other evaluator builds a tree fit to the
+// negative gradient, and then leafValue sets the value of each leaf from the rows that reached it.
+func fitSuccessor(ds *dataset.Dataset, rows []int, gradients, hessians []float64, evaluator decision_tree.Evaluator, options decision_tree.BuildOptions, leafValue func(rows []int) float64) (*decision_tree.DecisionNode, error) {
+	if secondOrder, ok := evaluator.(decision_tree.SecondOrderEvaluator); ok {
+		sampledGradients := make([]float64, len(rows))
+		sampledHessians := make([]float64, len(rows))
+		for i, r := range rows {
+			sampledGradients[i] = gradients[r]
+			sampledHessians[i] = hessians[r]
+		}
+		return decision_tree.BuildSecondOrderTree(ds.Subset(rows), sampledGradients, sampledHessians, secondOrder, options)
+	}
+
+	residuals := make([]float64, len(gradients))
+	for r := range gradients {
+		residuals[r] = -gradients[r]
+	}
+	tree, err := decision_tree.BuildTreeWithOverfitting(withTargets(ds, rows, residuals), evaluator, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setLeafValues(tree, ds, rows, leafValue)
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// setLeafValues routes the rows of ds at the provided indices through tree, and overrides the value
+// of every leaf that was reached, using the indices of the rows that landed in it
+func setLeafValues(tree *decision_tree.DecisionNode, ds *dataset.Dataset, rows []int, value func(rows []int) float64) error {
+	leafRows := map[*decision_tree.DecisionNode][]int{}
+	for _, r := range rows {
+		leaf, err := tree.Leaf(ds.Rows[r].X())
+		if err != nil {
+			return err
+		}
+		leafRows[leaf] = append(leafRows[leaf], r)
+	}
+
+	for leaf, rows := range leafRows {
+		v := value(rows)
+		leaf.Value = &v
+	}
+	return nil
+}
+
+// countClasses makes sure that ds can be used to build a classifier, and returns the number
+// of target classes. Classes are enum values, so they're numbered 0 through N-1.
+func countClasses(ds *dataset.Dataset) (int, error) {
+	if ds.ColumnIsContinuous[len(ds.ColumnIsContinuous)-1] {
+		return 0, fmt.Errorf("target labels must not be continuous for classification")
+	}
+
+	numClasses := 0
+	if ds.EnumMapper != nil {
+		numClasses = len((*ds.EnumMapper)[ds.ColumnNames[len(ds.ColumnNames)-1]])
+	}
+	for _, row := range ds.Rows {
+		if row.Y() < 0 || row.Y() != math.Trunc(row.Y()) {
+			return 0, fmt.Errorf("classification expects enum values as target classes, found %v", row.Y())
+		}
+		if int(row.Y()) >= numClasses {
+			numClasses = int(row.Y()) + 1
+		}
+	}
+	return numClasses, nil
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// softmax turns raw per-class predictions into probabilities that sum to 1
+func softmax(raw []float64) []float64 {
+	max := math.Inf(-1)
+	for _, v := range raw {
+		max = math.Max(max, v)
+	}
+
+	// subtracting the max doesn't change the result, but keeps math.Exp from overflowing
+	total := 0.0
+	out := make([]float64, len(raw))
+	for k, v := range raw {
+		out[k] = math.Exp(v - max)
+		total += out[k]
+	}
+	for k := range out {
+		out[k] /= total
+	}
+	return out
+}
+
+// withTargets builds a dataset from the rows of ds at the provided indices, replacing the target
+// of each row with the corresponding value in targets. The target column of the returned dataset
+// is always continuous.
+func withTargets(ds *dataset.Dataset, rows []int, targets []float64) *dataset.Dataset {
+	continuous := make([]bool, len(ds.ColumnIsContinuous))
+	copy(continuous, ds.ColumnIsContinuous)
+	continuous[len(continuous)-1] = true
+
+	out := dataset.NewDataset(ds.ColumnNames, continuous, []dataset.Row{}, ds.EnumMapper)
+	for _, r := range rows {
+		newRow := make(dataset.Row, len(ds.Rows[r]))
+		copy(newRow, ds.Rows[r])
+		newRow[len(newRow)-1] = targets[r]
+		out.InsertWeightedRow(newRow, ds.Weight(r))
+	}
+	return out
+}
@@ -0,0 +1,551 @@
+package boosting
+
+import (
+	"math"
+	"testing"
+
+	"robertkotcher.me/ML2022/dataset"
+	"robertkotcher.me/ML2022/decision_tree"
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+// buildRegressionDataset builds a small dataset where y = x^2
+func buildRegressionDataset() *dataset.Dataset {
+	ds := dataset.NewDataset(
+		[]string{"x", "y"},
+		[]bool{true, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for x := 0.0; x < 20; x++ {
+		ds.InsertRow(dataset.Row{x, x * x})
+	}
+	return ds
+}
+
+func meanSquaredError(t *testing.T, m *BoostingModel, ds *dataset.Dataset) float64 {
+	total := 0.0
+	for _, row := range ds.Rows {
+		pred, err := m.Predict(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += (*pred - row.Y()) * (*pred - row.Y())
+	}
+	return total / float64(ds.Size())
+}
+
+func TestBuildGradiantBoostingModel(t *testing.T) {
+	ds := buildRegressionDataset()
+
+	options := BuildOptions{
+		LearningRate:  0.1,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(2)},
+		NumIterations: 0,
+	}
+	rootOnly, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with no successors, the model predicts the average target
+	pred, err := rootOnly.Predict(dataset.Row{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(*pred-123.5) > 1e-9 {
+		t.Errorf("expected root prediction 123.5, got %v", *pred)
+	}
+
+	options.NumIterations = 50
+	model, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*model.Successors) != 50 {
+		t.Errorf("expected 50 successors, got %d", len(*model.Successors))
+	}
+
+	rootErr := meanSquaredError(t, rootOnly, ds)
+	modelErr := meanSquaredError(t, model, ds)
+	if modelErr > rootErr/10 {
+		t.Errorf("expected boosting to reduce error, root %v vs model %v", rootErr, modelErr)
+	}
+}
+
+func TestBuildGradiantBoostingModelBinary(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "class"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	// class 1 is more likely as x grows, with some overlap in the middle
+	for x := 0.0; x < 30; x++ {
+		class := 0.0
+		if x >= 15 || x == 10 {
+			class = 1
+		}
+		if x == 20 {
+			class = 0
+		}
+		ds.InsertRow(dataset.Row{x, class})
+	}
+
+	options := BuildOptions{
+		LearningRate:  0.3,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(2)},
+		NumIterations: 20,
+	}
+	model, err := BuildGradiantBoostingModel(ds, decision_tree.ClassificationEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Objective != BinaryClassification {
+		t.Fatal("expected a binary classification model")
+	}
+
+	low, err := model.Predict(dataset.Row{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := model.Predict(dataset.Row{27})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *low >= 0.5 || *high <= 0.5 || *low < 0 || *high > 1 {
+		t.Errorf("expected probabilities on either side of 0.5, got %v and %v", *low, *high)
+	}
+
+	class, err := model.PredictClass(dataset.Row{27})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *class != 1 {
+		t.Errorf("expected class 1, got %v", *class)
+	}
+}
+
+func TestBuildGradiantBoostingModelMulticlass(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "class"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{"class": {"low", "mid", "high"}},
+	)
+	for x := 0.0; x < 30; x++ {
+		ds.InsertRow(dataset.Row{x, math.Floor(x / 10)})
+	}
+
+	options := BuildOptions{
+		LearningRate:  0.3,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(2)},
+		NumIterations: 20,
+	}
+	model, err := BuildGradiantBoostingModel(ds, decision_tree.ClassificationEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Objective != MulticlassClassification || len(*model.ClassModels) != 3 {
+		t.Fatal("expected a multiclass model with 3 class models")
+	}
+
+	probabilities, err := model.PredictProba(dataset.Row{15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0.0
+	for _, p := range *probabilities {
+		total += p
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("expected probabilities to sum to 1, got %v", total)
+	}
+
+	for _, row := range ds.Rows {
+		class, err := model.PredictClass(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *class != row.Y() {
+			t.Errorf("expected class %v for x=%v, got %v", row.Y(), row[0], *class)
+		}
+	}
+
+	if _, err := model.Predict(dataset.Row{15}); err == nil {
+		t.Error("expected Predict to fail for a multiclass model")
+	}
+}
+
+func TestBuildAdaBoostModel(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "class"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	// no single stump can separate a band of class 1 in the middle
+	for x := 0.0; x < 30; x++ {
+		class := 0.0
+		if x >= 10 && x < 20 {
+			class = 1
+		}
+		ds.InsertRow(dataset.Row{x, class})
+	}
+
+	options := BuildOptions{LearningRate: 1, NumIterations: 10}
+	model, roundErrors, err := BuildAdaBoostModel(ds, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*roundErrors) != len(*model.Successors) || len(*model.LearnerWeights) != len(*model.Successors) {
+		t.Fatalf("expected one error and weight per stump, got %d errors and %d weights for %d stumps",
+			len(*roundErrors), len(*model.LearnerWeights), len(*model.Successors))
+	}
+	if (*roundErrors)[0] <= 0 {
+		t.Errorf("expected the first stump to misclassify some rows")
+	}
+	for _, stump := range *model.Successors {
+		leaf, err := stump.Leaf(ds.Rows[0].X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Value == nil {
+			t.Errorf("expected the class of each stump's leaves to be cached")
+		}
+	}
+
+	for _, row := range ds.Rows {
+		class, err := model.Predict(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *class != row.Y() {
+			t.Errorf("expected class %v for x=%v, got %v", row.Y(), row[0], *class)
+		}
+	}
+
+	// without a learning rate, stumps vote like standard SAMME
+	defaults, _, err := BuildAdaBoostModel(ds, BuildOptions{NumIterations: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.LearningRate != 1 {
+		t.Errorf("expected a default learning rate of 1, got %v", defaults.LearningRate)
+	}
+	for _, row := range ds.Rows {
+		shares, err := defaults.PredictProba(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !((*shares)[int(row.Y())] > .5) {
+			t.Errorf("expected most of the vote for class %v at x=%v, got %v", row.Y(), row[0], *shares)
+		}
+	}
+}
+
+func TestBuildGradiantBoostingModelLosses(t *testing.T) {
+	ds := buildRegressionDataset()
+	losses := []Loss{
+		SquaredError{},
+		AbsoluteError{},
+		Huber{Delta: 10},
+		Quantile{Alpha: 0.9},
+		Poisson{},
+	}
+
+	for _, loss := range losses {
+		options := BuildOptions{
+			LearningRate:  0.1,
+			TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(2)},
+			NumIterations: 30,
+			Loss:          loss,
+		}
+		model, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rootLoss, modelLoss := 0.0, 0.0
+		for _, row := range ds.Rows {
+			raw, err := model.rawPredict(row.X())
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootLoss += loss.Value(row.Y(), *model.Root.Value)
+			modelLoss += loss.Value(row.Y(), *raw)
+		}
+		if modelLoss >= rootLoss {
+			t.Errorf("%T: expected boosting to reduce loss, root %v vs model %v", loss, rootLoss, modelLoss)
+		}
+	}
+}
+
+func TestBuildGradiantBoostingModelEarlyStopping(t *testing.T) {
+	train := buildRegressionDataset()
+	validation := buildRegressionDataset()
+	// add noise to the training targets so that later successors overfit
+	for r := range train.Rows {
+		train.Rows[r][1] += float64((r*7)%5-2) * 20
+	}
+
+	options := BuildOptions{
+		LearningRate:  0.5,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(5)},
+		NumIterations: 100,
+		Validation:    validation,
+		Patience:      5,
+	}
+	model, err := BuildGradiantBoostingModel(train, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := model.History
+	if history == nil {
+		t.Fatal("expected the model to have a history")
+	}
+	if len(history.TrainScores) != len(history.ValidationScores) {
+		t.Errorf("expected train and validation curves of the same length")
+	}
+	if len(history.ValidationScores) >= options.NumIterations+1 {
+		t.Errorf("expected training to stop early, ran %d iterations", len(history.ValidationScores)-1)
+	}
+	if len(*model.Successors) != history.BestIteration {
+		t.Errorf("expected model to be truncated to %d successors, had %d", history.BestIteration, len(*model.Successors))
+	}
+	for _, score := range history.ValidationScores {
+		if score < history.ValidationScores[history.BestIteration] {
+			t.Errorf("best iteration %d doesn't have the lowest validation score", history.BestIteration)
+		}
+	}
+}
+
+func TestBuildGradiantBoostingModelSubsampling(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"a", "b", "c", "y"},
+		[]bool{true, true, true, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for x := 0.0; x < 40; x++ {
+		ds.InsertRow(dataset.Row{x, math.Mod(x*7, 11), math.Mod(x*3, 5), x*x + math.Mod(x*7, 11)})
+	}
+
+	options := BuildOptions{
+		LearningRate:    0.1,
+		TreeOptions:     decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(3)},
+		NumIterations:   30,
+		Subsample:       0.5,
+		ColSampleByTree: 0.7,
+		ColSampleByNode: 0.5,
+		Seed:            42,
+	}
+	first, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, successor := range *first.Successors {
+		if successor.TrainData.Size() != 20 {
+			t.Fatalf("expected successors to be trained on 20 rows, had %d", successor.TrainData.Size())
+		}
+	}
+
+	// the same seed should build the same model
+	for _, row := range ds.Rows {
+		a, err := first.Predict(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := second.Predict(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *a != *b {
+			t.Fatalf("expected models built with the same seed to agree, got %v and %v", *a, *b)
+		}
+	}
+
+	options.NumIterations = 0
+	rootOnly, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meanSquaredError(t, first, ds) >= meanSquaredError(t, rootOnly, ds) {
+		t.Error("expected stochastic boosting to reduce error")
+	}
+}
+
+func TestBuildGradiantBoostingModelSecondOrder(t *testing.T) {
+	ds := buildRegressionDataset()
+
+	options := BuildOptions{
+		Evaluator:     decision_tree.SecondOrderEvaluator{Lambda: 1},
+		LearningRate:  0.3,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(3)},
+		NumIterations: 30,
+	}
+	model, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.NumIterations = 0
+	rootOnly, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meanSquaredError(t, model, ds) > meanSquaredError(t, rootOnly, ds)/10 {
+		t.Error("expected regularized boosting to reduce error")
+	}
+	for _, successor := range *model.Successors {
+		leaf, err := successor.Leaf(ds.Rows[0].X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Value == nil || *leaf.Value != options.Evaluator.Predict(leaf) {
+			t.Errorf("expected each leaf to keep its regularized weight in Value")
+		}
+	}
+
+	// no split can gain more than gamma, so every successor is a single leaf
+	options.Evaluator = decision_tree.SecondOrderEvaluator{Lambda: 1, Gamma: 1e12}
+	options.NumIterations = 5
+	pruned, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, successor := range *pruned.Successors {
+		if successor.Partition != nil {
+			t.Error("expected gamma to prevent every split")
+		}
+	}
+}
+
+func TestStagedPredictAndMetricCurve(t *testing.T) {
+	ds := buildRegressionDataset()
+
+	options := BuildOptions{
+		LearningRate:  0.1,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(2)},
+		NumIterations: 10,
+		Validation:    ds,
+	}
+	model, err := BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages, err := model.StagedPredict(dataset.Row{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, err := model.Predict(dataset.Row{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*stages) != 11 || (*stages)[0] != *model.Root.Value || (*stages)[10] != *pred {
+		t.Errorf("expected staged predictions to go from the root to the full model, got %v", *stages)
+	}
+
+	// without patience, the history holds the full curve
+	curve, err := model.MetricCurve(ds, MeanSquaredError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range *curve {
+		if math.Abs((*curve)[i]-model.History.ValidationScores[i]) > 1e-9 {
+			t.Errorf("expected curve to match history at iteration %d, got %v and %v", i, (*curve)[i], model.History.ValidationScores[i])
+		}
+	}
+
+	truncated, err := model.Truncated(BestIteration(*curve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*truncated.Successors) != 10 || len(*model.Successors) != 10 {
+		t.Errorf("expected the best training curve iteration to be the last")
+	}
+}
+
+func TestBuildGradiantBoostingModelDART(t *testing.T) {
+	train := buildRegressionDataset()
+	validation := buildRegressionDataset()
+	for r := range train.Rows {
+		train.Rows[r][1] += float64((r*7)%5-2) * 20
+	}
+
+	options := BuildOptions{
+		LearningRate:  0.3,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(3)},
+		NumIterations: 40,
+		Validation:    validation,
+		Patience:      10,
+		DropRate:      0.3,
+		Seed:          7,
+	}
+	model, err := BuildGradiantBoostingModel(train, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.LearnerWeights == nil || len(*model.LearnerWeights) != len(*model.Successors) {
+		t.Fatal("expected a weight for each successor")
+	}
+	rescaled := false
+	for _, weight := range *model.LearnerWeights {
+		if weight != 1 {
+			rescaled = true
+		}
+	}
+	if !rescaled {
+		t.Error("expected dropout to rescale some successors")
+	}
+
+	// predictions should agree with the scores tracked while training, including after truncation
+	curve, err := model.MetricCurve(validation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	best := model.History.ValidationScores[model.History.BestIteration]
+	if math.Abs((*curve)[len(*curve)-1]-best) > 1e-6 {
+		t.Errorf("expected the truncated model to score %v, got %v", best, (*curve)[len(*curve)-1])
+	}
+	if best >= model.History.ValidationScores[0] {
+		t.Error("expected DART to improve on the root")
+	}
+
+	multiclass := dataset.NewDataset(
+		[]string{"x", "class"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{"class": {"low", "mid", "high"}},
+	)
+	for x := 0.0; x < 30; x++ {
+		multiclass.InsertRow(dataset.Row{x, math.Floor(x / 10)})
+	}
+	options = BuildOptions{
+		LearningRate:  0.3,
+		TreeOptions:   decision_tree.BuildOptions{MaxDepth: ptr.PointToInt(2)},
+		NumIterations: 30,
+		DropRate:      0.2,
+		Seed:          7,
+	}
+	classifier, err := BuildGradiantBoostingModel(multiclass, decision_tree.ClassificationEvaluator{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range multiclass.Rows {
+		class, err := classifier.PredictClass(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *class != row.Y() {
+			t.Errorf("expected class %v for x=%v, got %v", row.Y(), row[0], *class)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package boosting
+
+import (
+	"math/rand"
+
+	"robertkotcher.me/ML2022/dataset"
+	"robertkotcher.me/ML2022/decision_tree"
+)
+
+// dropout picks the successors that DART leaves out of each iteration. A nil dropout never drops
+// anything, which is plain gradient boosting.
+type dropout struct {
+	rng      *rand.Rand
+	dropRate float64
+	skipDrop float64
+}
+
+// newDropout returns nil unless options turn on DART
+func newDropout(options BuildOptions, rng *rand.Rand) *dropout {
+	if options.DropRate <= 0 {
+		return nil
+	}
+	return &dropout{rng: rng, dropRate: options.DropRate, skipDrop: options.SkipDrop}
+}
+
+// drop returns the (sorted) indices of the successors to leave out of the next iteration, out of
+// the first n
+func (d *dropout) drop(n int) []int {
+	if d == nil || n == 0 || d.rng.Float64() < d.skipDrop {
+		return nil
+	}
+
+	dropped := []int{}
+	for i := 0; i < n; i++ {
+		if d.rng.Float64() < d.dropRate {
+			dropped = append(dropped, i)
+		}
+	}
+	return dropped
+}
+
+// weights returns the weight of a new successor fit while numDropped successors were left out, and
+// the factor that the dropped successors' weights are scaled by. The new successor is fit to what
+// the dropped ones predicted, so together they're scaled back down to about what the dropped ones
+// predicted alone.
+func (d *dropout) weights(numDropped int, learningRate float64) (float64, float64) {
+	if d == nil || numDropped == 0 {
+		return 1, 1
+	}
+	k := float64(numDropped)
+	return 1 / (k + learningRate), k / (k + learningRate)
+}
+
+// trackedOutput keeps the raw predictions of one model output (one class, for multiclass models)
+// up to date for every training and validation row as successors are added, so that each
+// iteration doesn't have to predict with every earlier successor again
+type trackedOutput struct {
+	learningRate float64
+	// weights holds the weight of each successor, which stays 1 unless DART rescales it
+	weights []float64
+	train   []float64
+	valid   []float64
+	// trainOutputs[i] and validOutputs[i] hold successor i's prediction for each row. They're only
+	// kept for DART, which needs to take dropped successors back out of the predictions.
+	keepOutputs  bool
+	trainOutputs [][]float64
+	validOutputs [][]float64
+}
+
+// newTrackedOutput starts every training and validation row at the initial raw prediction.
+// validation may be nil.
+func newTrackedOutput(initial float64, ds, validation *dataset.Dataset, learningRate float64, keepOutputs bool) *trackedOutput {
+	o := trackedOutput{
+		learningRate: learningRate,
+		weights:      []float64{},
+		train:        make([]float64, ds.Size()),
+		keepOutputs:  keepOutputs,
+	}
+	for r := range o.train {
+		o.train[r] = initial
+	}
+	if validation != nil {
+		o.valid = make([]float64, validation.Size())
+		for r := range o.valid {
+			o.valid[r] = initial
+		}
+	}
+	return &o
+}
+
+// withoutDropped returns the raw training predictions with the dropped successors left out
+func (o *trackedOutput) withoutDropped(dropped []int) []float64 {
+	out := append([]float64{}, o.train...)
+	for _, i := range dropped {
+		for r := range out {
+			out[r] -= o.learningRate * o.weights[i] * o.trainOutputs[i][r]
+		}
+	}
+	return out
+}
+
+// add adds a successor with the provided weight to the predictions, after scaling the weight of
+// each dropped successor by dropScale
+func (o *trackedOutput) add(successor *decision_tree.DecisionNode, ds, validation *dataset.Dataset, weight float64, dropped []int, dropScale float64) error {
+	trainOutput, err := predictAll(successor, ds)
+	if err != nil {
+		return err
+	}
+	var validOutput []float64
+	if validation != nil {
+		validOutput, err = predictAll(successor, validation)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, i := range dropped {
+		change := o.learningRate * o.weights[i] * (dropScale - 1)
+		for r := range o.train {
+			o.train[r] += change * o.trainOutputs[i][r]
+		}
+		for r := range o.valid {
+			o.valid[r] += change * o.validOutputs[i][r]
+		}
+		o.weights[i] *= dropScale
+	}
+
+	for r := range o.train {
+		o.train[r] += o.learningRate * weight * trainOutput[r]
+	}
+	for r := range o.valid {
+		o.valid[r] += o.learningRate * weight * validOutput[r]
+	}
+	o.weights = append(o.weights, weight)
+
+	if o.keepOutputs {
+		o.trainOutputs = append(o.trainOutputs, trainOutput)
+		o.validOutputs = append(o.validOutputs, validOutput)
+	}
+	return nil
+}
+
+// predictAll returns tree's prediction for each row of ds
+func predictAll(tree *decision_tree.DecisionNode, ds *dataset.Dataset) ([]float64, error) {
+	out := make([]float64, ds.Size())
+	for r, row := range ds.Rows {
+		pred, err := tree.Predict(row.X())
+		if err != nil {
+			return nil, err
+		}
+		out[r] = *pred
+	}
+	return out, nil
+}
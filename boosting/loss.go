@@ -0,0 +1,295 @@
+package boosting
+
+import (
+	"math"
+	"sort"
+)
+
+// Loss is a differentiable loss function minimized by gradient boosting. Losses work on "raw"
+// predictions, i.e. the root's prediction plus the scaled sum of successor predictions, which
+// may live on a different scale than the target (e.g. log-odds for LogLoss).
+type Loss interface {
+	// Value returns the loss of a single raw prediction
+	Value(actual, raw float64) float64
+	// Gradient returns the derivative of the loss with respect to the raw prediction. Successor
+	// trees are fit to the negative gradient.
+	Gradient(actual, raw float64) float64
+	// Hessian returns the second derivative of the loss with respect to the raw prediction
+	Hessian(actual, raw float64) float64
+	// InitialPrediction returns the constant raw prediction that minimizes the loss for targets
+	InitialPrediction(targets []float64) float64
+	// LeafValue returns the amount to add to the raw predictions of the rows that reached a leaf
+	// that minimizes their loss, given each row's target and current raw prediction
+	LeafValue(actual, raw []float64) float64
+	// Transform maps a raw prediction onto the scale of the target
+	Transform(raw float64) float64
+}
+
+// SquaredError is .5 * (actual - raw)^2. Its negative gradient is the plain residual.
+type SquaredError struct {
+}
+
+func (l SquaredError) Value(actual, raw float64) float64 {
+	return 0.5 * (actual - raw) * (actual - raw)
+}
+
+func (l SquaredError) Gradient(actual, raw float64) float64 {
+	return raw - actual
+}
+
+func (l SquaredError) Hessian(actual, raw float64) float64 {
+	return 1
+}
+
+// InitialPrediction is the average target
+func (l SquaredError) InitialPrediction(targets []float64) float64 {
+	return mean(targets)
+}
+
+// LeafValue is the average residual
+func (l SquaredError) LeafValue(actual, raw []float64) float64 {
+	return mean(residuals(actual, raw))
+}
+
+func (l SquaredError) Transform(raw float64) float64 {
+	return raw
+}
+
+// AbsoluteError is |actual - raw|. It's less sensitive to outliers than SquaredError.
+type AbsoluteError struct {
+}
+
+func (l AbsoluteError) Value(actual, raw float64) float64 {
+	return math.Abs(actual - raw)
+}
+
+func (l AbsoluteError) Gradient(actual, raw float64) float64 {
+	return sign(raw - actual)
+}
+
+// Hessian is 0 almost everywhere, so leaves are solved with LeafValue instead. This returns 1 so
+// that second order learners fall back to fitting the gradient.
+func (l AbsoluteError) Hessian(actual, raw float64) float64 {
+	return 1
+}
+
+// InitialPrediction is the median target
+func (l AbsoluteError) InitialPrediction(targets []float64) float64 {
+	return quantile(targets, 0.5)
+}
+
+// LeafValue is the median residual
+func (l AbsoluteError) LeafValue(actual, raw []float64) float64 {
+	return quantile(residuals(actual, raw), 0.5)
+}
+
+func (l AbsoluteError) Transform(raw float64) float64 {
+	return raw
+}
+
+// Huber is squared error for residuals smaller than Delta, and absolute error beyond that. Delta
+// must be positive.
+type Huber struct {
+	Delta float64
+}
+
+func (l Huber) Value(actual, raw float64) float64 {
+	residual := math.Abs(actual - raw)
+	if residual <= l.Delta {
+		return 0.5 * residual * residual
+	}
+	return l.Delta * (residual - 0.5*l.Delta)
+}
+
+func (l Huber) Gradient(actual, raw float64) float64 {
+	residual := actual - raw
+	if math.Abs(residual) <= l.Delta {
+		return -residual
+	}
+	return -l.Delta * sign(residual)
+}
+
+// Hessian is 1 within Delta of the target and 0 outside it, so leaves are solved with LeafValue
+// instead, see AbsoluteError.Hessian
+func (l Huber) Hessian(actual, raw float64) float64 {
+	return 1
+}
+
+// InitialPrediction is the median target
+func (l Huber) InitialPrediction(targets []float64) float64 {
+	return quantile(targets, 0.5)
+}
+
+// LeafValue is Friedman's approximation: the median residual, plus the average (clipped)
+// deviation from it
+func (l Huber) LeafValue(actual, raw []float64) float64 {
+	r := residuals(actual, raw)
+	median := quantile(r, 0.5)
+
+	total := 0.0
+	for _, v := range r {
+		deviation := v - median
+		total += sign(deviation) * math.Min(l.Delta, math.Abs(deviation))
+	}
+	return median + total/float64(len(r))
+}
+
+func (l Huber) Transform(raw float64) float64 {
+	return raw
+}
+
+// Quantile is the pinball loss, which makes the model predict the Alpha quantile of the target
+// instead of its mean. Alpha must be between 0 and 1.
+type Quantile struct {
+	Alpha float64
+}
+
+func (l Quantile) Value(actual, raw float64) float64 {
+	if actual > raw {
+		return l.Alpha * (actual - raw)
+	}
+	return (1 - l.Alpha) * (raw - actual)
+}
+
+func (l Quantile) Gradient(actual, raw float64) float64 {
+	if actual > raw {
+		return -l.Alpha
+	}
+	return 1 - l.Alpha
+}
+
+// Hessian is 0 almost everywhere, see AbsoluteError.Hessian
+func (l Quantile) Hessian(actual, raw float64) float64 {
+	return 1
+}
+
+// InitialPrediction is the Alpha quantile of targets
+func (l Quantile) InitialPrediction(targets []float64) float64 {
+	return quantile(targets, l.Alpha)
+}
+
+// LeafValue is the Alpha quantile of the residuals
+func (l Quantile) LeafValue(actual, raw []float64) float64 {
+	return quantile(residuals(actual, raw), l.Alpha)
+}
+
+func (l Quantile) Transform(raw float64) float64 {
+	return raw
+}
+
+// Poisson is the Poisson deviance (up to a constant) for non-negative count targets. Raw
+// predictions are the log of the expected count.
+type Poisson struct {
+}
+
+func (l Poisson) Value(actual, raw float64) float64 {
+	return math.Exp(raw) - actual*raw
+}
+
+func (l Poisson) Gradient(actual, raw float64) float64 {
+	return math.Exp(raw) - actual
+}
+
+func (l Poisson) Hessian(actual, raw float64) float64 {
+	return math.Exp(raw)
+}
+
+// InitialPrediction is the log of the average target
+func (l Poisson) InitialPrediction(targets []float64) float64 {
+	return math.Log(math.Max(mean(targets), minHessian))
+}
+
+// LeafValue is log(sum(actual) / sum(predicted count))
+func (l Poisson) LeafValue(actual, raw []float64) float64 {
+	totalActual, totalPredicted := 0.0, 0.0
+	for i := range actual {
+		totalActual += actual[i]
+		totalPredicted += math.Exp(raw[i])
+	}
+	return math.Log(math.Max(totalActual, minHessian) / math.Max(totalPredicted, minHessian))
+}
+
+func (l Poisson) Transform(raw float64) float64 {
+	return math.Exp(raw)
+}
+
+// LogLoss is the binary cross-entropy for targets 0 and 1. Raw predictions are log-odds of
+// the positive class.
+type LogLoss struct {
+}
+
+func (l LogLoss) Value(actual, raw float64) float64 {
+	// log(1 + e^raw) - actual * raw, written so that it doesn't overflow for large raw
+	return math.Max(raw, 0) + math.Log1p(math.Exp(-math.Abs(raw))) - actual*raw
+}
+
+func (l LogLoss) Gradient(actual, raw float64) float64 {
+	return sigmoid(raw) - actual
+}
+
+func (l LogLoss) Hessian(actual, raw float64) float64 {
+	p := sigmoid(raw)
+	return p * (1 - p)
+}
+
+// InitialPrediction is log(p / (1 - p)), where p is the fraction of positive targets
+func (l LogLoss) InitialPrediction(targets []float64) float64 {
+	p := mean(targets)
+	p = math.Min(math.Max(p, minHessian), 1-minHessian)
+	return math.Log(p / (1 - p))
+}
+
+// LeafValue takes a single Newton step: sum(actual - p) / sum(p * (1 - p))
+func (l LogLoss) LeafValue(actual, raw []float64) float64 {
+	numerator, denominator := 0.0, 0.0
+	for i := range actual {
+		numerator -= l.Gradient(actual[i], raw[i])
+		denominator += l.Hessian(actual[i], raw[i])
+	}
+	return numerator / math.Max(denominator, minHessian)
+}
+
+// Transform returns the probability of the positive class
+func (l LogLoss) Transform(raw float64) float64 {
+	return sigmoid(raw)
+}
+
+func mean(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// residuals returns actual - raw for each pair
+func residuals(actual, raw []float64) []float64 {
+	out := make([]float64, len(actual))
+	for i := range actual {
+		out[i] = actual[i] - raw[i]
+	}
+	return out
+}
+
+// quantile returns the alpha quantile of values, interpolating between the closest two
+func quantile(values []float64, alpha float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	position := alpha * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	fraction := position - float64(lower)
+	return sorted[lower] + fraction*(sorted[upper]-sorted[lower])
+}
+
+func sign(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
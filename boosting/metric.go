@@ -0,0 +1,151 @@
+package boosting
+
+import (
+	"math"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// Metric scores predictions against actual targets, where lower scores are better. Each
+// prediction holds what Predict would return for that row, or, for multiclass models, the
+// probability of each class as returned by PredictProba.
+type Metric func(actual []float64, predictions [][]float64) float64
+
+// MeanSquaredError is the average squared difference between target and prediction
+func MeanSquaredError(actual []float64, predictions [][]float64) float64 {
+	total := 0.0
+	for i := range actual {
+		total += (actual[i] - predictions[i][0]) * (actual[i] - predictions[i][0])
+	}
+	return total / float64(len(actual))
+}
+
+// MeanAbsoluteError is the average absolute difference between target and prediction
+func MeanAbsoluteError(actual []float64, predictions [][]float64) float64 {
+	total := 0.0
+	for i := range actual {
+		total += math.Abs(actual[i] - predictions[i][0])
+	}
+	return total / float64(len(actual))
+}
+
+// CrossEntropy is the average negative log probability given to the actual class. Binary
+// predictions are the probability of the positive class.
+func CrossEntropy(actual []float64, predictions [][]float64) float64 {
+	total := 0.0
+	for i := range actual {
+		p := classProbability(predictions[i], actual[i])
+		total -= math.Log(math.Max(p, minHessian))
+	}
+	return total / float64(len(actual))
+}
+
+// ErrorRate is the fraction of rows whose most probable class isn't the actual class. Binary
+// predictions are the probability of the positive class.
+func ErrorRate(actual []float64, predictions [][]float64) float64 {
+	total := 0.0
+	for i := range actual {
+		if predictedClass(predictions[i]) != actual[i] {
+			total += 1
+		}
+	}
+	return total / float64(len(actual))
+}
+
+// History holds a model's score after each iteration. Index i is the score of the model with
+// i successors (per class), so index 0 is the score of the root alone.
+type History struct {
+	TrainScores      []float64
+	ValidationScores []float64
+	// BestIteration is the number of successors (per class) with the lowest validation score
+	BestIteration int
+}
+
+// defaultMetric returns the metric used when BuildOptions doesn't set one
+func defaultMetric(objective Objective) Metric {
+	if objective == WeightedVote {
+		return ErrorRate
+	}
+	if objective == BinaryClassification || objective == MulticlassClassification {
+		return CrossEntropy
+	}
+	return MeanSquaredError
+}
+
+// earlyStopper keeps track of training and validation scores as a model is built, and decides
+// when training should stop
+type earlyStopper struct {
+	metric      Metric
+	patience    int
+	history     History
+	trainActual []float64
+	validActual []float64
+}
+
+func newEarlyStopper(ds *dataset.Dataset, options BuildOptions, objective Objective) *earlyStopper {
+	e := earlyStopper{metric: options.Metric, patience: options.Patience}
+	if e.metric == nil {
+		e.metric = defaultMetric(objective)
+	}
+	e.trainActual = targetsOf(ds)
+	e.validActual = targetsOf(options.Validation)
+	return &e
+}
+
+// record adds the scores of the current iteration to the history, and returns true if the
+// validation score hasn't improved for 'patience' iterations
+func (e *earlyStopper) record(trainPredictions, validPredictions [][]float64) bool {
+	e.history.TrainScores = append(e.history.TrainScores, e.metric(e.trainActual, trainPredictions))
+	e.history.ValidationScores = append(e.history.ValidationScores, e.metric(e.validActual, validPredictions))
+
+	latest := len(e.history.ValidationScores) - 1
+	if e.history.ValidationScores[latest] < e.history.ValidationScores[e.history.BestIteration] {
+		e.history.BestIteration = latest
+	}
+
+	return e.patience > 0 && latest-e.history.BestIteration >= e.patience
+}
+
+// improved returns true if the latest iteration has the best validation score so far
+func (e *earlyStopper) improved() bool {
+	return e.history.BestIteration == len(e.history.ValidationScores)-1
+}
+
+// classProbability returns the probability that a prediction gives class
+func classProbability(prediction []float64, class float64) float64 {
+	if len(prediction) == 1 {
+		if class == 1 {
+			return prediction[0]
+		}
+		return 1 - prediction[0]
+	}
+	return prediction[int(class)]
+}
+
+// predictedClass returns the most probable class of a prediction. Like PredictClass, ties go to
+// the lower class.
+func predictedClass(prediction []float64) float64 {
+	if len(prediction) == 1 {
+		if prediction[0] >= 0.5 {
+			return 1
+		}
+		return 0
+	}
+
+	class := 0
+	for k, p := range prediction {
+		if p > prediction[class] {
+			class = k
+		}
+	}
+	return float64(class)
+}
+
+// targetsOf returns the target of each row in ds
+func targetsOf(ds *dataset.Dataset) []float64 {
+	targets := make([]float64, ds.Size())
+	for r, row := range ds.Rows {
+		targets[r] = row.Y()
+	}
+	return targets
+}
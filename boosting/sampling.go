@@ -0,0 +1,63 @@
+package boosting
+
+import (
+	"math/rand"
+
+	"robertkotcher.me/ML2022/dataset"
+	"robertkotcher.me/ML2022/decision_tree"
+)
+
+// sampler draws the rows and columns that each successor is trained on, for stochastic
+// gradient boosting
+type sampler struct {
+	rng        *rand.Rand
+	options    BuildOptions
+	allRows    []int
+	allColumns []int
+}
+
+func newSampler(ds *dataset.Dataset, options BuildOptions) *sampler {
+	s := sampler{
+		rng:        rand.New(rand.NewSource(options.Seed)),
+		options:    options,
+		allRows:    make([]int, ds.Size()),
+		allColumns: options.TreeOptions.Columns,
+	}
+	for r := range s.allRows {
+		s.allRows[r] = r
+	}
+	if s.allColumns == nil {
+		s.allColumns = make([]int, len(ds.ColumnNames)-1)
+		for c := range s.allColumns {
+			s.allColumns[c] = c
+		}
+	}
+	return &s
+}
+
+// rows returns the indices of the rows that the next successor is trained on
+func (s *sampler) rows() []int {
+	if !isSampled(s.options.Subsample) {
+		return s.allRows
+	}
+	return dataset.SampleIndices(s.rng, s.allRows, s.options.Subsample)
+}
+
+// treeOptions returns the options used to build the next successor, restricted to a sample of
+// the columns
+func (s *sampler) treeOptions(options decision_tree.BuildOptions) decision_tree.BuildOptions {
+	if isSampled(s.options.ColSampleByTree) {
+		options.Columns = dataset.SampleIndices(s.rng, s.allColumns, s.options.ColSampleByTree)
+	}
+	if isSampled(s.options.ColSampleByNode) {
+		fraction := s.options.ColSampleByNode
+		options.ColumnSampleByNode = &fraction
+		options.Rand = s.rng
+	}
+	return options
+}
+
+// isSampled returns true if fraction means that only part of the data should be used
+func isSampled(fraction float64) bool {
+	return fraction > 0 && fraction < 1
+}
@@ -0,0 +1,242 @@
+package boosting
+
+import (
+	"fmt"
+	"math"
+
+	"robertkotcher.me/ML2022/dataset"
+	"robertkotcher.me/ML2022/decision_tree"
+)
+
+// StagedPredict returns what Predict would return for row if the model only had its first i
+// successors, for every i. Index 0 is the prediction of the root alone. This lets us look at how
+// the model changes with more iterations without retraining it.
+func (m BoostingModel) StagedPredict(row dataset.Row) (*[]float64, error) {
+	if m.Objective == MulticlassClassification {
+		return nil, fmt.Errorf("multiclass models predict one probability per class, use StagedPredictProba")
+	}
+
+	stages, err := m.stagedOutputs(row)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]float64, len(stages))
+	for i, stage := range stages {
+		if m.Objective == WeightedVote {
+			out[i] = predictedClass(stage)
+		} else {
+			out[i] = stage[0]
+		}
+	}
+	return &out, nil
+}
+
+// StagedPredictProba returns what PredictProba would return for row if the model only had its
+// first i successors, for every i. Index 0 uses the root alone, or, for weighted vote models, gives
+// every class the same probability.
+func (m BoostingModel) StagedPredictProba(row dataset.Row) (*[][]float64, error) {
+	if m.Objective == Regression {
+		return nil, fmt.Errorf("cannot predict class probabilities with a regression model")
+	}
+
+	stages, err := m.stagedOutputs(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Objective == BinaryClassification {
+		for i, stage := range stages {
+			stages[i] = []float64{1 - stage[0], stage[0]}
+		}
+	}
+	return &stages, nil
+}
+
+// MetricCurve scores the model's predictions for ds after each iteration, where index i is the
+// score of the model with its first i successors (per class). When metric is nil, the curve uses
+// the same default as early stopping, or ErrorRate for weighted vote models.
+func (m BoostingModel) MetricCurve(ds *dataset.Dataset, metric Metric) (*[]float64, error) {
+	if metric == nil {
+		metric = defaultMetric(m.Objective)
+	}
+
+	// stages[i][r] is the prediction for row r with i successors
+	var stages [][][]float64
+	for r, row := range ds.Rows {
+		rowStages, err := m.stagedOutputs(row.X())
+		if err != nil {
+			return nil, err
+		}
+		if stages == nil {
+			stages = make([][][]float64, len(rowStages))
+			for i := range stages {
+				stages[i] = make([][]float64, ds.Size())
+			}
+		}
+		for i, stage := range rowStages {
+			stages[i][r] = stage
+		}
+	}
+
+	actual := targetsOf(ds)
+	curve := make([]float64, len(stages))
+	for i, predictions := range stages {
+		curve[i] = metric(actual, predictions)
+	}
+	return &curve, nil
+}
+
+// stagedOutputs returns the model's output for row after each successor, in the form expected by
+// a Metric
+func (m BoostingModel) stagedOutputs(row dataset.Row) ([][]float64, error) {
+	switch m.Objective {
+	case MulticlassClassification:
+		// rawStages[k][i] is the raw prediction of class k with i successors
+		rawStages := make([][]float64, len(*m.ClassModels))
+		for k, classModel := range *m.ClassModels {
+			classStages, err := classModel.stagedRaw(row)
+			if err != nil {
+				return nil, err
+			}
+			rawStages[k] = classStages
+		}
+
+		out := make([][]float64, len(rawStages[0]))
+		for i := range out {
+			raw := make([]float64, len(rawStages))
+			for k := range rawStages {
+				raw[k] = rawStages[k][i]
+			}
+			out[i] = softmax(raw)
+		}
+		return out, nil
+	case WeightedVote:
+		votes := make([]float64, m.NumClasses)
+		totalVote := 0.0
+
+		// with no votes yet, every class is equally likely
+		out := [][]float64{uniform(m.NumClasses)}
+		for i, s := range *m.Successors {
+			pred, err := s.Predict(row)
+			if err != nil {
+				return nil, err
+			}
+			votes[int(*pred)] += (*m.LearnerWeights)[i]
+			totalVote += (*m.LearnerWeights)[i]
+
+			shares := make([]float64, m.NumClasses)
+			for k := range votes {
+				shares[k] = votes[k] / totalVote
+			}
+			out = append(out, shares)
+		}
+		return out, nil
+	}
+
+	rawStages, err := m.stagedRaw(row)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]float64, len(rawStages))
+	for i, raw := range rawStages {
+		if m.Loss != nil {
+			raw = m.Loss.Transform(raw)
+		}
+		out[i] = []float64{raw}
+	}
+	return out, nil
+}
+
+// stagedRaw returns the raw prediction for row with the root alone, and then after adding each
+// successor
+func (m BoostingModel) stagedRaw(row dataset.Row) ([]float64, error) {
+	if m.Root == nil {
+		return nil, fmt.Errorf("cannot predict with a boosting model that has no root")
+	}
+
+	out, err := m.Root.Predict(row)
+	if err != nil {
+		return nil, err
+	}
+	total := *out
+	stages := []float64{total}
+
+	if m.Successors != nil {
+		for i, s := range *m.Successors {
+			sOut, err := s.Predict(row)
+			if err != nil {
+				return nil, err
+			}
+			total += m.LearningRate * m.learnerWeight(i) * (*sOut)
+			stages = append(stages, total)
+		}
+	}
+	return stages, nil
+}
+
+// Truncated returns a copy of the model that only keeps its first n successors (per class), e.g.
+// the best iteration of a curve returned by MetricCurve
+func (m BoostingModel) Truncated(n int) (*BoostingModel, error) {
+	out := m
+	switch m.Objective {
+	case MulticlassClassification:
+		classModels := make([]BoostingModel, len(*m.ClassModels))
+		for k, classModel := range *m.ClassModels {
+			truncated, err := classModel.Truncated(n)
+			if err != nil {
+				return nil, err
+			}
+			classModels[k] = *truncated
+		}
+		out.ClassModels = &classModels
+		return &out, nil
+	}
+
+	if m.LearnerWeights != nil {
+		if n > len(*m.LearnerWeights) {
+			return nil, fmt.Errorf("cannot keep %d successors of a model with %d", n, len(*m.LearnerWeights))
+		}
+		learnerWeights := append([]float64{}, (*m.LearnerWeights)[:n]...)
+		out.LearnerWeights = &learnerWeights
+	}
+
+	if m.Successors == nil || n > len(*m.Successors) {
+		return nil, fmt.Errorf("cannot keep %d successors of a model with fewer", n)
+	}
+	successors := append([]decision_tree.DecisionNode{}, (*m.Successors)[:n]...)
+	out.Successors = &successors
+	return &out, nil
+}
+
+// learnerWeight returns the weight that successor i's prediction is scaled by, which is 1 unless
+// the model was built with DART
+func (m BoostingModel) learnerWeight(i int) float64 {
+	if m.LearnerWeights == nil {
+		return 1
+	}
+	return (*m.LearnerWeights)[i]
+}
+
+// uniform returns n equal probabilities
+func uniform(n int) []float64 {
+	out := make([]float64, n)
+	for k := range out {
+		out[k] = 1 / float64(n)
+	}
+	return out
+}
+
+// BestIteration returns the index of the lowest score in a curve, e.g. one returned by MetricCurve.
+// This is the number of successors (per class) that scored best.
+func BestIteration(curve []float64) int {
+	best := 0
+	lowest := math.Inf(1)
+	for i, score := range curve {
+		if score < lowest {
+			best = i
+			lowest = score
+		}
+	}
+	return best
+}
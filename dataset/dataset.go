@@ -3,8 +3,10 @@ package dataset
 import (
 	"encoding/csv"
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
@@ -26,6 +28,9 @@ type Dataset struct {
 	ColumnNames        []string
 	ColumnIsContinuous []bool
 	Rows               []Row
+	// Weights holds an optional weight for each row in Rows. When nil, every row has a
+	// weight of 1.
+	Weights []float64
 }
 
 // columnIndex and columnIsCont are used to help build datsets from CSV files.
@@ -114,9 +119,11 @@ func BuildDatasetFromCSV(filepath string, cti ColumnsToInclude, targetC columnNa
 		}
 	}
 
-	// now that we have the map, iterate through rows again and construct Rows
+	// now that we have the map, iterate through rows again and construct Rows. continuous
+	// features that can't be parsed are missing, and kept as NaN. rows are only skipped when
+	// their (continuous) target can't be parsed.
 	rows := []Row{}
-	var numSkipped int
+	var numSkipped, numMissing int
 	for row := 1; row < len(data); row++ {
 		var newRow Row
 		includeRow := true
@@ -125,10 +132,13 @@ func BuildDatasetFromCSV(filepath string, cti ColumnsToInclude, targetC columnNa
 			val := data[row][col]
 			if columnContinuous[c] {
 				fl, err := strconv.ParseFloat(val, 64)
-				if err != nil {
+				if err != nil && c == len(columnIndices)-1 {
 					// logrus.Warnf("skipping row %d. error parsing \"%v\" (row %d col %d)", row, val, row, col)
 					numSkipped += 1
 					includeRow = false
+				} else if err != nil {
+					numMissing += 1
+					newRow = append(newRow, math.NaN())
 				} else {
 					newRow = append(newRow, fl)
 				}
@@ -141,7 +151,7 @@ func BuildDatasetFromCSV(filepath string, cti ColumnsToInclude, targetC columnNa
 		}
 	}
 
-	logrus.Infof("finished building dataset. skipped %d records", numSkipped)
+	logrus.Infof("finished building dataset. skipped %d records, %d missing values", numSkipped, numMissing)
 
 	ds := NewDataset(columnNames, columnContinuous, rows, &e)
 	return ds, nil
@@ -157,12 +167,31 @@ func NewDataset(names []string, continuous []bool, rows []Row, e *EnumMapper) *D
 }
 func (d *Dataset) Shuffle() {
 	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(d.Rows), func(i, j int) { d.Rows[i], d.Rows[j] = d.Rows[j], d.Rows[i] })
+	rand.Shuffle(len(d.Rows), func(i, j int) {
+		d.Rows[i], d.Rows[j] = d.Rows[j], d.Rows[i]
+		if d.Weights != nil {
+			d.Weights[i], d.Weights[j] = d.Weights[j], d.Weights[i]
+		}
+	})
 }
 
 // InsertRow inserts a new row into d
 func (d *Dataset) InsertRow(r Row) {
+	d.InsertWeightedRow(r, 1)
+}
+
+// InsertWeightedRow inserts a new row into d with the provided weight
+func (d *Dataset) InsertWeightedRow(r Row, weight float64) {
+	if d.Weights == nil && weight != 1 {
+		d.Weights = make([]float64, len(d.Rows))
+		for i := range d.Weights {
+			d.Weights[i] = 1
+		}
+	}
 	d.Rows = append(d.Rows, r)
+	if d.Weights != nil {
+		d.Weights = append(d.Weights, weight)
+	}
 }
 
 // Size returns the number of data points in this dataset
@@ -170,6 +199,64 @@ func (d *Dataset) Size() int {
 	return len(d.Rows)
 }
 
+// Weight returns the weight of the row at index r
+func (d *Dataset) Weight(r int) float64 {
+	if d.Weights == nil {
+		return 1
+	}
+	return d.Weights[r]
+}
+
+// TotalWeight returns the sum of all row weights. For unweighted datasets this is the size.
+func (d *Dataset) TotalWeight() float64 {
+	if d.Weights == nil {
+		return float64(d.Size())
+	}
+	total := 0.0
+	for _, w := range d.Weights {
+		total += w
+	}
+	return total
+}
+
+// WithWeights returns a copy of d that shares its rows, but has the provided row weights
+func (d *Dataset) WithWeights(weights []float64) (*Dataset, error) {
+	if len(weights) != d.Size() {
+		return nil, fmt.Errorf("expected %d weights, had %d", d.Size(), len(weights))
+	}
+	out := NewDataset(d.ColumnNames, d.ColumnIsContinuous, d.Rows, d.EnumMapper)
+	out.Weights = weights
+	return out, nil
+}
+
+// Subset returns a new dataset holding the rows (and weights) at the provided indices
+func (d *Dataset) Subset(indices []int) *Dataset {
+	out := d.cloneColumns()
+	for _, r := range indices {
+		out.InsertWeightedRow(d.Rows[r], d.Weight(r))
+	}
+	return out
+}
+
+// SampleIndices returns a random fraction of indices, drawn without replacement and returned in
+// ascending order. At least one index is always returned.
+func SampleIndices(rng *rand.Rand, indices []int, fraction float64) []int {
+	n := int(math.Round(fraction * float64(len(indices))))
+	if n < 1 {
+		n = 1
+	}
+	if n >= len(indices) {
+		return indices
+	}
+
+	sampled := make([]int, n)
+	for i, p := range rng.Perm(len(indices))[:n] {
+		sampled[i] = indices[p]
+	}
+	sort.Ints(sampled)
+	return sampled
+}
+
 // cloneColumns creates a new dataset with the same columns and types
 func (d *Dataset) cloneColumns() *Dataset {
 	return NewDataset(d.ColumnNames, d.ColumnIsContinuous, []Row{}, d.EnumMapper)
@@ -189,27 +276,47 @@ func (d *Dataset) cloneColumns() *Dataset {
 //
 // returns:
 //
-// map[bool] {
-// 		false: Dataset <all rows where q.ColumnName evaluated to false>
-//		true: Dataset <all rows where q.ColumnName evaluated to false>
-// }
+//	map[bool] {
+//			false: Dataset <all rows where q.ColumnName evaluated to false>
+//			true: Dataset <all rows where q.ColumnName evaluated to false>
+//	}
 func (d *Dataset) PartitionByName(column string, on float64) (*Partition, error) {
+	return d.PartitionWithMissing(column, on, false)
+}
+
+// PartitionWithMissing partitions the dataset like PartitionByName, except that rows whose value
+// for the column is missing (NaN) go to the true dataset if missingGoesTrue is set. They go to the
+// false dataset otherwise.
+func (d *Dataset) PartitionWithMissing(column string, on float64, missingGoesTrue bool) (*Partition, error) {
+	return d.partition(Partition{ColumnName: column, Value: on, MissingGoesTrue: missingGoesTrue})
+}
+
+// PartitionByCategories partitions the dataset on a categorical column, where rows whose value is
+// one of categories are true, and every other row is false. Rows missing a value go to the true
+// dataset if missingGoesTrue is set.
+func (d *Dataset) PartitionByCategories(column string, categories []float64, missingGoesTrue bool) (*Partition, error) {
+	sorted := append([]float64{}, categories...)
+	sort.Float64s(sorted)
+	return d.partition(Partition{ColumnName: column, Categories: sorted, MissingGoesTrue: missingGoesTrue})
+}
+
+// partition fills in the column index, type, and datasets of p, which must name one of d's columns
+func (d *Dataset) partition(p Partition) (*Partition, error) {
 	for i, c := range d.ColumnNames {
-		if column == c {
-			p := Partition{
-				ColumnIndex:  i,
-				ColumnName:   column,
-				IsContinuous: d.ColumnIsContinuous[i],
-				Value:        on,
-				False:        d.cloneColumns(),
-				True:         d.cloneColumns(),
+		if p.ColumnName == c {
+			p.ColumnIndex = i
+			p.IsContinuous = d.ColumnIsContinuous[i]
+			if p.IsContinuous && p.Categories != nil {
+				return nil, fmt.Errorf("cannot partition continuous column %s by categories", c)
 			}
+			p.False = d.cloneColumns()
+			p.True = d.cloneColumns()
 
-			for _, row := range d.Rows {
+			for r, row := range d.Rows {
 				if p.EvaluateRow(row) {
-					p.True.InsertRow(row)
+					p.True.InsertWeightedRow(row, d.Weight(r))
 				} else {
-					p.False.InsertRow(row)
+					p.False.InsertWeightedRow(row, d.Weight(r))
 				}
 			}
 
@@ -217,7 +324,7 @@ func (d *Dataset) PartitionByName(column string, on float64) (*Partition, error)
 		}
 	}
 
-	return nil, fmt.Errorf("could not find column with name %s", column)
+	return nil, fmt.Errorf("could not find column with name %s", p.ColumnName)
 }
 
 // CrossValidationSets returns pointer to 10 training sets and 10 corresponding test sets
@@ -238,9 +345,9 @@ func (d *Dataset) CrossValidationSets() (trainsets *[10]Dataset, testsets *[10]D
 		testsets[s] = *d.cloneColumns()
 		for r, row := range d.Rows {
 			if r >= testStartIdx && r < testEndIdx { // include in test set
-				testsets[s].InsertRow(row)
+				testsets[s].InsertWeightedRow(row, d.Weight(r))
 			} else { // include in train set
-				trainsets[s].InsertRow(row)
+				trainsets[s].InsertWeightedRow(row, d.Weight(r))
 			}
 		}
 	}
@@ -1,6 +1,8 @@
 package dataset
 
 import (
+	"math"
+	"os"
 	"testing"
 )
 
@@ -36,3 +38,80 @@ func TestCrossValidationSets(t *testing.T) {
 		t.Error("failed to create the correc train set")
 	}
 }
+
+func TestPartitionByNameKeepsWeights(t *testing.T) {
+	ds := NewDataset(
+		[]string{"x", "y"},
+		[]bool{true, false},
+		[]Row{},
+		&EnumMapper{},
+	)
+
+	ds.InsertRow(Row{0, 0})
+	ds.InsertWeightedRow(Row{1, 1}, 3)
+	ds.InsertWeightedRow(Row{2, 1}, 0.5)
+
+	if ds.Weight(0) != 1 || ds.TotalWeight() != 4.5 {
+		t.Errorf("expected unweighted rows to have a weight of 1, total weight was %v", ds.TotalWeight())
+	}
+
+	p, err := ds.PartitionByName("x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.True.TotalWeight() != 3.5 || p.False.TotalWeight() != 1 {
+		t.Errorf("expected weights to follow their rows, got %v and %v", p.True.TotalWeight(), p.False.TotalWeight())
+	}
+
+	// 1 - (1/4.5)^2 - (3.5/4.5)^2
+	if math.Abs(ds.GiniImpurity()-0.345679) > 1e-6 {
+		t.Errorf("expected weighted gini impurity of 0.345679, got %v", ds.GiniImpurity())
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	ds := NewDataset(
+		[]string{"x", "y"},
+		[]bool{true, false},
+		[]Row{},
+		&EnumMapper{},
+	)
+
+	ds.InsertRow(Row{0, 0})
+	ds.InsertRow(Row{1, 0})
+	if ds.Entropy() != 0 {
+		t.Errorf("expected a single label to have no entropy, got %v", ds.Entropy())
+	}
+
+	ds.InsertWeightedRow(Row{2, 1}, 2)
+	if math.Abs(ds.Entropy()-1) > 1e-9 {
+		t.Errorf("expected two equally weighted labels to have 1 bit of entropy, got %v", ds.Entropy())
+	}
+}
+
+func TestBuildDatasetFromCSVKeepsMissingValues(t *testing.T) {
+	path := t.TempDir() + "/people.csv"
+	csv := "Age,Sex,Survived\n22,male,0\n,female,1\n38,female,x\n"
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := BuildDatasetFromCSV(path, ColumnsToInclude{"Age": true, "Sex": false, "Survived": true}, "Survived")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the row with no age is kept, and the row with an unparsable target is skipped
+	if ds.Size() != 2 {
+		t.Fatalf("expected 2 rows, had %d", ds.Size())
+	}
+	for _, row := range ds.Rows {
+		age := row[0]
+		if ds.ColumnNames[1] == "Age" {
+			age = row[1]
+		}
+		if row.Y() == 1 && !math.IsNaN(age) {
+			t.Errorf("expected a missing age to be NaN, got %v", age)
+		}
+	}
+}
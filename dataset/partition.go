@@ -1,17 +1,39 @@
 package dataset
 
+import (
+	"math"
+	"sort"
+)
+
 type Partition struct {
 	ColumnIndex  int
 	ColumnName   string
 	Value        float64
 	IsContinuous bool
-	False        *Dataset
-	True         *Dataset
+	// Categories, when set on a categorical column, holds the (sorted) categories that evaluate
+	// true, instead of only Value
+	Categories []float64
+	// MissingGoesTrue is the side that rows with a missing (NaN) value for the column go to
+	MissingGoesTrue bool
+	False           *Dataset
+	True            *Dataset
 }
 
 func (p Partition) EvaluateRow(r Row) bool {
+	if p.IsMissing(r) {
+		return p.MissingGoesTrue
+	}
 	if p.IsContinuous {
 		return r[p.ColumnIndex] > p.Value
 	}
+	if p.Categories != nil {
+		i := sort.SearchFloat64s(p.Categories, r[p.ColumnIndex])
+		return i < len(p.Categories) && p.Categories[i] == r[p.ColumnIndex]
+	}
 	return r[p.ColumnIndex] == p.Value
 }
+
+// IsMissing returns true if r has no value for the partition's column
+func (p Partition) IsMissing(r Row) bool {
+	return math.IsNaN(r[p.ColumnIndex])
+}
@@ -2,30 +2,55 @@ package dataset
 
 import (
 	"fmt"
+	"math"
 )
 
 // GiniImpurity is a measure of how often a data point in the set would be
 // _incorrectly_ labeled if a random label from the set was assigned to
 // a data point in the set.
 //
-// Used by CART (classification and regression tree) algorithms
+// Used by CART (classification and regression tree) algorithms. Rows count towards
+// their label in proportion to their weight.
 func (d *Dataset) GiniImpurity() float64 {
 	classes := map[float64]float64{}
 
-	for _, r := range d.Rows {
+	for i, r := range d.Rows {
 		label := r[len(r)-1]
-		classes[label] += 1.0
+		classes[label] += d.Weight(i)
 	}
 
 	impurity := 1.0
+	totalWeight := d.TotalWeight()
 	for label := range classes {
-		ratio := classes[label] / float64(d.Size())
+		ratio := classes[label] / totalWeight
 		impurity -= (ratio * ratio)
 	}
 
 	return impurity
 }
 
+// Entropy is the Shannon entropy (in bits) of the labels in the set. It's 0 when every data
+// point has the same label. Rows count towards their label in proportion to their weight.
+func (d *Dataset) Entropy() float64 {
+	classes := map[float64]float64{}
+
+	for i, r := range d.Rows {
+		label := r[len(r)-1]
+		classes[label] += d.Weight(i)
+	}
+
+	entropy := 0.0
+	totalWeight := d.TotalWeight()
+	for label := range classes {
+		if classes[label] > 0 {
+			ratio := classes[label] / totalWeight
+			entropy -= ratio * math.Log2(ratio)
+		}
+	}
+
+	return entropy
+}
+
 // VarianceForRows prints out the variance that each row has
 func (d *Dataset) VarianceForRows() (map[int]float64, error) {
 	if d.Size() == 1 {
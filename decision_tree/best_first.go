@@ -0,0 +1,116 @@
+package decision_tree
+
+import (
+	"fmt"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// expansion is a leaf of a tree that's being grown best-first, along with the split that would
+// expand it
+type expansion struct {
+	node  *DecisionNode
+	depth int
+	split *nodeSplit
+	// decrease is how much split lowers the tree's total impurity
+	decrease float64
+}
+
+// buildTreeBestFirst grows a tree from ds by always splitting the leaf whose split lowers impurity
+// the most, until the tree has options.MaxLeafNodes leaves. Ties go to the leaf that was found
+// first.
+func buildTreeBestFirst(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions, h *histogram) (*DecisionNode, error) {
+	if *options.MaxLeafNodes < 1 {
+		return nil, fmt.Errorf("cannot grow a tree with %d leaf nodes", *options.MaxLeafNodes)
+	}
+
+	root, err := newExpansion(ds, evaluator, options, 1, h)
+	if err != nil {
+		return nil, err
+	}
+
+	// leaves that can still be split
+	frontier := []*expansion{}
+	if root.split != nil {
+		frontier = append(frontier, root)
+	}
+
+	for leaves := 1; leaves < *options.MaxLeafNodes && len(frontier) > 0; leaves++ {
+		best := 0
+		for i, e := range frontier {
+			if e.decrease > frontier[best].decrease {
+				best = i
+			}
+		}
+		e := frontier[best]
+		frontier = append(frontier[:best], frontier[best+1:]...)
+
+		r, err := newExpansion(e.split.partition.True, evaluator, options, e.depth+1, e.split.trueHistogram)
+		if err != nil {
+			return nil, err
+		}
+		l, err := newExpansion(e.split.partition.False, evaluator, options, e.depth+1, e.split.falseHistogram)
+		if err != nil {
+			return nil, err
+		}
+		e.node.Partition, e.node.Surrogates = e.split.partition, e.split.surrogates
+		e.node.R, e.node.L = r.node, l.node
+
+		for _, child := range []*expansion{r, l} {
+			if child.split != nil {
+				frontier = append(frontier, child)
+			}
+		}
+	}
+
+	return root.node, nil
+}
+
+// newExpansion returns a leaf for ds at depth, along with the split that would expand it and how
+// much that split lowers impurity
+func newExpansion(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions, depth int, h *histogram) (*expansion, error) {
+	node, split, err := splitNode(ds, evaluator, options, depth, h)
+	if err != nil {
+		return nil, err
+	}
+	e := expansion{node: node, depth: depth, split: split}
+	if split != nil {
+		e.decrease, err = impurityDecrease(evaluator, ds, split.partition)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// impurityDecrease returns the total impurity of ds less the total impurity of both sides of
+// partition
+func impurityDecrease(evaluator Evaluator, ds *dataset.Dataset, partition *dataset.Partition) (float64, error) {
+	decrease, err := totalImpurity(evaluator, ds)
+	if err != nil {
+		return 0, err
+	}
+	for _, side := range []*dataset.Dataset{partition.False, partition.True} {
+		impurity, err := totalImpurity(evaluator, side)
+		if err != nil {
+			return 0, err
+		}
+		decrease -= impurity
+	}
+	return decrease, nil
+}
+
+// totalImpurity returns the impurity of ds times its total weight for evaluators with an
+// ImpurityMeasure, and the total error of ds as a leaf otherwise
+func totalImpurity(evaluator Evaluator, ds *dataset.Dataset) (float64, error) {
+	if measure, ok := evaluator.(ImpurityMeasure); ok {
+		_, impurity := measure.Impurity(ds)
+		return impurity * ds.TotalWeight(), nil
+	}
+
+	totalError, err := evaluator.GetErrorAtNode(&DecisionNode{Evaluator: evaluator, TrainData: ds})
+	if err != nil {
+		return 0, err
+	}
+	return *totalError, nil
+}
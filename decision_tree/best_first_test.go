@@ -0,0 +1,89 @@
+package decision_tree
+
+import (
+	"math"
+	"testing"
+
+	"robertkotcher.me/ML2022/dataset"
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+func TestMaxLeafNodes(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "y"},
+		[]bool{true, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	// the small half is pure, so only the large half is worth splitting again
+	for x := 0.0; x < 20; x++ {
+		y := 0.0
+		if x >= 15 {
+			y = 100
+		} else if x >= 10 {
+			y = 50
+		}
+		ds.InsertRow(dataset.Row{x, y})
+	}
+
+	tree, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxLeafNodes: ptr.PointToInt(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Partition == nil || tree.Partition.Value != 9 {
+		t.Fatalf("expected the root to split on x > 9, got %+v", tree.Partition)
+	}
+	if tree.L.Partition != nil {
+		t.Errorf("expected the pure side to stay a leaf, got %+v", tree.L.Partition)
+	}
+	if tree.R.Partition == nil || tree.R.Partition.Value != 14 {
+		t.Errorf("expected the large side to split on x > 14, got %+v", tree.R.Partition)
+	}
+
+	for _, evaluator := range []Evaluator{RegressionEvaluator{}, ClassificationEvaluator{}, SecondOrderEvaluator{Lambda: 1}} {
+		_, classification := evaluator.(ClassificationEvaluator)
+		ds := buildSplitDataset(classification)
+
+		// best-first can't grow more leaves than a tree without limits has
+		unlimited, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlimitedLeaves, err := unlimited.getLeaves()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, maxLeaves := range []int{1, 2, 5, 8} {
+			tree, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{MaxLeafNodes: ptr.PointToInt(maxLeaves)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaves, err := tree.getLeaves()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := int(math.Min(float64(maxLeaves), float64(len(*unlimitedLeaves))))
+			if len(*leaves) != expected {
+				t.Errorf("expected %d leaves, got %d", expected, len(*leaves))
+			}
+		}
+
+		// with enough leaves, best-first grows the same tree as depth-first
+		depthFirst, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{MaxDepth: ptr.PointToInt(4)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bestFirst, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{MaxDepth: ptr.PointToInt(4), MaxLeafNodes: ptr.PointToInt(1000)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameSplits(depthFirst, bestFirst) {
+			t.Errorf("expected an unlimited number of leaves to grow the same tree as depth-first")
+		}
+	}
+
+	if _, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxLeafNodes: ptr.PointToInt(0)}); err == nil {
+		t.Errorf("expected an error for a tree without leaves")
+	}
+}
@@ -2,7 +2,7 @@ package decision_tree
 
 import (
 	"fmt"
-	"math"
+	"math/rand"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -12,6 +12,60 @@ import (
 type BuildOptions struct {
 	MaxDepth           *int
 	MinSamplesForSplit *int
+	// Columns restricts split search to these feature columns (by index) when set
+	Columns []int
+	// ColumnSampleByNode is the fraction of columns (from Columns, if set) that each node picks
+	// at random to search for a split. Rand must be set along with it.
+	ColumnSampleByNode *float64
+	// Rand is the source of randomness for any sampling while building the tree
+	Rand *rand.Rand
+	// MaxBins, when set, sorts the values of each continuous column into at most MaxBins bins of
+	// about the same size before building, and only splits on bin edges. Each node then sums its
+	// rows into a histogram per column, and one child's histogram is the parent's less its
+	// sibling's, so large datasets don't need to be sorted at every node. The evaluator must
+	// implement StatsEvaluator.
+	MaxBins *int
+	// Workers is the number of columns whose splits are searched at once. The tree doesn't depend
+	// on it, but the evaluator must be safe to use from several goroutines. Columns are searched
+	// one at a time when nil.
+	Workers *int
+	// MaxSurrogates is the number of surrogate splits that each node keeps for rows missing its
+	// primary column. Training rows missing it are also partitioned by its surrogates. Nodes keep
+	// no surrogates when nil, and rows missing the primary column follow its default direction.
+	MaxSurrogates *int
+	// CategorySubsets lets categorical columns split into any two subsets of their categories,
+	// rather than one category against the rest. Categories are ordered by their mean target, which
+	// finds the best subset split for squared error, Friedman MSE, Poisson deviance, second order
+	// trees, and Gini or entropy with two classes. Other evaluators, and classification nodes with
+	// more than two classes, still split one category against the rest.
+	CategorySubsets bool
+	// MaxLeafNodes, when set, grows the tree best-first instead of depth-first: the leaf whose split
+	// lowers impurity the most is always split next, until the tree has MaxLeafNodes leaves (or no
+	// leaf can be split). The other options still limit which leaves can be split.
+	MaxLeafNodes *int
+	// MinSamplesLeaf is the fewest rows that each side of a split can have. Splits that would leave
+	// fewer on either side aren't considered.
+	MinSamplesLeaf *int
+	// MinWeightFractionLeaf is the smallest fraction of the total row weight of the tree that each
+	// side of a split can have, like MinSamplesLeaf for weighted rows
+	MinWeightFractionLeaf *float64
+	// MinImpurityDecrease is how much a node's best split must lower the tree's total impurity,
+	// as a fraction of the total row weight of the tree, for the node to be split. Impurity is the
+	// evaluator's ImpurityMeasure, or its error at the node otherwise.
+	MinImpurityDecrease *float64
+	// MaxFeatures is the number of columns (from Columns, after ColumnSampleByNode) that each node
+	// picks at random to search for a split. Rand must be set along with it.
+	MaxFeatures *int
+	// CCPAlpha is the cost-complexity parameter that Fit prunes trees with. Fit picks it by
+	// cross-validation when nil. BuildTreeWithOverfitting doesn't prune, and ignores it.
+	CCPAlpha *float64
+	// AlphaSelection is how Fit and GetAlphaIndexFromCrossValidation pick an alpha from the
+	// cross-validated errors of a pruning path. It defaults to MinimumCVError.
+	AlphaSelection AlphaSelection
+
+	// rootWeight is the total row weight of the tree, which MinWeightFractionLeaf and
+	// MinImpurityDecrease are fractions of
+	rootWeight float64
 }
 
 type DecisionNode struct {
@@ -20,142 +74,212 @@ type DecisionNode struct {
 	Partition *dataset.Partition
 	L         *DecisionNode
 	R         *DecisionNode
+	// Value overrides the evaluator's prediction for this node when set. This is useful when
+	// a node's output can't be derived from its training targets alone (e.g. boosting leaves)
+	Value *float64
+	// Surrogates are backup splits for rows missing the Partition's column, best first
+	Surrogates []Surrogate
 }
 
 // BuildTreeWithOverfitting turns a dataset and its evaluator into a decision tree, returning the
 // root node. The depth is initially set to 1. A tree with depth 1 will consist of just the root.
 func BuildTreeWithOverfitting(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions) (*DecisionNode, error) {
-	return buildTreeWithOverfitting(ds, evaluator, options, 1)
+	options.rootWeight = ds.TotalWeight()
+
+	var h *histogram
+	if options.MaxBins != nil {
+		statsEvaluator, ok := evaluator.(StatsEvaluator)
+		if !ok {
+			return nil, fmt.Errorf("binning columns requires an evaluator that implements StatsEvaluator")
+		}
+		bins, err := newBinning(ds, *options.MaxBins)
+		if err != nil {
+			return nil, err
+		}
+		h, err = histogramOf(ds, bins, statsEvaluator)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.MaxLeafNodes != nil {
+		return buildTreeBestFirst(ds, evaluator, options, h)
+	}
+	return buildTreeWithOverfitting(ds, evaluator, options, 1, h)
 }
 
-// buildTreeWithOverfitting is a private BuildTreeWithOverfitting that includes current depth info
-func buildTreeWithOverfitting(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions, depth int) (*DecisionNode, error) {
+// buildTreeWithOverfitting is a private BuildTreeWithOverfitting that includes current depth info,
+// and the histogram of ds when columns are binned
+func buildTreeWithOverfitting(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions, depth int, h *histogram) (*DecisionNode, error) {
+	outNode, split, err := splitNode(ds, evaluator, options, depth, h)
+	if err != nil {
+		return nil, err
+	}
+	if split == nil {
+		return outNode, nil
+	}
+	outNode.Partition, outNode.Surrogates = split.partition, split.surrogates
+
+	// the Right subtree is built from True partition
+	r, err := buildTreeWithOverfitting(split.partition.True, evaluator, options, depth+1, split.trueHistogram)
+	if err != nil {
+		return nil, err
+	}
+	outNode.R = r
+
+	// the Left subtree is built from False partition
+	l, err := buildTreeWithOverfitting(split.partition.False, evaluator, options, depth+1, split.falseHistogram)
+	if err != nil {
+		return nil, err
+	}
+	outNode.L = l
+
+	return outNode, nil
+}
+
+// nodeSplit is the split that a node would make, along with the histograms of both sides when
+// columns are binned
+type nodeSplit struct {
+	partition                     *dataset.Partition
+	surrogates                    []Surrogate
+	trueHistogram, falseHistogram *histogram
+}
+
+// splitNode returns a leaf for ds at depth, and the split it should make to stop being one. The
+// split is nil when the node should stay a leaf.
+func splitNode(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions, depth int, h *histogram) (*DecisionNode, *nodeSplit, error) {
 	if ds.Size() == 0 {
-		return nil, fmt.Errorf("cannot initialize a decision tree node without data")
+		return nil, nil, fmt.Errorf("cannot initialize a decision tree node without data")
 	}
 
 	outNode := DecisionNode{Evaluator: evaluator, TrainData: ds}
 
 	// if user has provided a max depth and we've hit that max, just return the node without partitioning
-	if options.MaxDepth != nil && depth < *(options.MaxDepth) {
-		return &outNode, nil
+	if options.MaxDepth != nil && depth >= *(options.MaxDepth) {
+		return &outNode, nil, nil
 	}
 
 	// check to see if we have too few leaves to split in this node. this is one way to prevent over-fitting
 	if options.MinSamplesForSplit != nil && ds.Size() < *(options.MinSamplesForSplit) {
-		return &outNode, nil
+		return &outNode, nil, nil
 	}
 
 	// _always_ partition this data. we might end up with all leaves on one side, which means
 	// that this node will be a leaf.
-	var bestPartition *dataset.Partition
-	var bestScore *float64
-	for c := 0; c < len(ds.ColumnNames)-1; c++ {
-		for r := 0; r < len(ds.Rows); r++ {
-			name := ds.ColumnNames[c]
-			val := ds.Rows[r][c]
-
-			partition, err := ds.PartitionByName(name, val)
-			if err != nil {
-				return nil, err
-			}
-
-			score, err := evaluator.EvaluateSplit(ds, partition)
-			if err != nil {
-				return nil, err
-			}
-
-			if bestScore == nil || evaluator.IsBetter(*score, *bestScore) {
-				bestPartition = partition
-				bestScore = score
-			}
-		}
+	columns, err := options.candidateColumns(ds)
+	if err != nil {
+		return nil, nil, err
+	}
+	bestPartition, bestScore, err := bestSplit(ds, evaluator, columns, h, options)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// return because no informative partition
-	if bestPartition.False.Size() == 0 || bestPartition.True.Size() == 0 {
-		return &outNode, nil
+	if bestPartition == nil || bestPartition.False.Size() == 0 || bestPartition.True.Size() == 0 {
+		return &outNode, nil, nil
 	}
 
-	outNode.Partition = bestPartition
+	// some evaluators can also tell us that even the best partition isn't worth making
+	if acceptor, ok := evaluator.(SplitAcceptor); ok && !acceptor.AcceptSplit(*bestScore) {
+		return &outNode, nil, nil
+	}
 
-	// the Right subtree is built from True partition
-	r, err := buildTreeWithOverfitting(bestPartition.True, evaluator, options, depth+1)
-	if err != nil {
-		return nil, err
+	if options.MinImpurityDecrease != nil {
+		decrease, err := impurityDecrease(evaluator, ds, bestPartition)
+		if err != nil {
+			return nil, nil, err
+		}
+		if decrease/options.rootWeight < *options.MinImpurityDecrease {
+			return &outNode, nil, nil
+		}
 	}
-	outNode.R = r
 
-	// the Left subtree is built from False partition
-	l, err := buildTreeWithOverfitting(bestPartition.False, evaluator, options, depth+1)
-	if err != nil {
-		return nil, err
+	split := nodeSplit{partition: bestPartition}
+
+	if options.MaxSurrogates != nil {
+		split.surrogates = findSurrogates(ds, bestPartition, *options.MaxSurrogates)
+		withSurrogates := DecisionNode{Partition: bestPartition, Surrogates: split.surrogates}
+		split.partition = withSurrogates.partitionBySurrogates(ds)
 	}
-	outNode.L = l
 
-	return &outNode, nil
+	if h != nil {
+		split.trueHistogram, split.falseHistogram, err = h.split(split.partition, evaluator.(StatsEvaluator))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &outNode, &split, nil
 }
 
-// GetAlphaIndexFromCrossValidation splits the training data into 10 folds, i.e. into
-// 10 groups of train/test subsets. For each training set, we build trees and alpha, and
-// determine which tree performs best. In the end, we average the 10 best alphas and determine
-// which alpha it's closet to in the incoming 'alphas' slice, returning the index. This index
-// can be used to select a tree constructed from _all_ of the training data, pruned with
-// cost-complexity pruning.
-func GetAlphaIndexFromCrossValidation(alphas []float64, ds *dataset.Dataset, evaluator Evaluator, options BuildOptions) (*int, error) {
-	ds.Shuffle()
-
-	// (1) build trainsets, testsets - each has 10 items
-	trainsets, testsets, err := ds.CrossValidationSets()
-	if err != nil {
-		return nil, err
+// workers returns the number of columns to search at once
+func (options BuildOptions) workers() int {
+	if options.Workers == nil {
+		return 1
 	}
+	return *options.Workers
+}
 
-	sumalphas := 0.0
-	for i, trainset := range trainsets {
-		testset := testsets[i]
+// leafLimits returns the fewest rows, and least total weight, that each side of a split can have
+func (options BuildOptions) leafLimits() leafLimits {
+	limits := leafLimits{}
+	if options.MinSamplesLeaf != nil {
+		limits.minSamples = *options.MinSamplesLeaf
+	}
+	if options.MinWeightFractionLeaf != nil {
+		limits.minWeight = *options.MinWeightFractionLeaf * options.rootWeight
+	}
+	return limits
+}
 
-		// build tree with training set
-		tree, _ := BuildTreeWithOverfitting(&trainset, evaluator, options)
-		subtrees, alphas, err := tree.GetSubtreesAndAlphas()
-		if err != nil {
-			return nil, err
+// candidateColumns returns the indices of the feature columns that a node may split on
+func (options BuildOptions) candidateColumns(ds *dataset.Dataset) ([]int, error) {
+	columns := options.Columns
+	if columns == nil {
+		columns = make([]int, len(ds.ColumnNames)-1)
+		for c := range columns {
+			columns[c] = c
 		}
+	}
 
-		// evaluate subtrees with test set
-		lowesterr := math.MaxFloat64
-		lowestalpha := math.MaxFloat64
-		for j, st := range *subtrees {
-			sterr := 0.0
-			for _, testrow := range testset.Rows {
-				pred, err := st.Predict(testrow.X())
-				if err != nil {
-					return nil, err
-				}
-
-				sterr += evaluator.GetSingleError(*pred, testrow.Y())
-			}
-			// logrus.Infof("fold %d: alpha %v, error: %v", i, (*alphas)[j], sterr)
-
-			if sterr < lowesterr {
-				lowesterr = sterr
-				lowestalpha = (*alphas)[j]
-			}
+	if options.ColumnSampleByNode == nil && options.MaxFeatures == nil {
+		return columns, nil
+	}
+	if options.Rand == nil {
+		return nil, fmt.Errorf("sampling columns by node requires Rand to be set")
+	}
+	if options.ColumnSampleByNode != nil {
+		columns = dataset.SampleIndices(options.Rand, columns, *options.ColumnSampleByNode)
+	}
+	if options.MaxFeatures != nil {
+		if *options.MaxFeatures < 1 {
+			return nil, fmt.Errorf("cannot search %d columns for a split", *options.MaxFeatures)
 		}
-		logrus.Infof("* fold %d: alpha %v, error: %v", i, lowestalpha, lowesterr)
-		sumalphas += lowestalpha
+		columns = dataset.SampleIndices(options.Rand, columns, float64(*options.MaxFeatures)/float64(len(columns)))
 	}
-	averageAlpha := sumalphas / 10.0
+	return columns, nil
+}
 
-	// find the alpha that we're closest to from passed alphas
-	for a, alpha := range alphas {
-		if averageAlpha <= alpha {
-			// if best alpha is 0 (lowest possible), then we'll hit it at index 0
-			return &a, nil
-		}
+// GetAlphaIndexFromCrossValidation splits the training data into 10 folds, i.e. into
+// 10 groups of train/test subsets, and grows and prunes a tree on each training set. Each of the
+// incoming 'alphas', which are the pruning path of a tree grown on _all_ of the training data,
+// is scored by the average error of the test sets on their fold's tree pruned with it. The index
+// of the alpha that options.AlphaSelection picks is returned, and can be used to select a tree
+// constructed from all of the training data, pruned with cost-complexity pruning. The error curve,
+// i.e. each alpha's cross-validated error and its standard error, is returned along with it. Fit
+// does all of this.
+func GetAlphaIndexFromCrossValidation(alphas []float64, ds *dataset.Dataset, evaluator Evaluator, options BuildOptions) (*int, []float64, []float64, error) {
+	scores, standardErrors, err := crossValidateAlphas(alphas, ds, evaluator, options)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return nil, fmt.Errorf("average alpha during cross validation was greater than all eligible alphas")
+	index, err := selectAlpha(scores, standardErrors, options.AlphaSelection)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &index, scores, standardErrors, nil
 }
 
 // GetSubtreesAndAlphas builds a list of subtrees and the alphas that would be required
@@ -199,14 +323,12 @@ func getSubtreesAndAlphas(root, iterNode *DecisionNode) (*DecisionNode, *float64
 
 	// get the current internal node's error (single-node view, as if it were leaf)
 	// --> this is R(t)
-	// --> results weighted based on proportion of total data points that made it
-	//     to this node.
-	iterAsLeafErr, err := iterNode.Evaluator.GetErrorAtNode(iterNode)
+	// --> results weighted based on proportion of total data points (by weight) that
+	//     made it to this node.
+	iterAsRootR, err := iterNode.risk(root)
 	if err != nil {
 		return nil, nil, err
 	}
-	iterWeight := float64(iterNode.TrainData.Size()) / float64(root.TrainData.Size())
-	iterAsRootR := *iterAsLeafErr * iterWeight
 
 	// iterate through all leaf nodes under iterNode and calculate the same thing as above,
 	// this time adding the results to get a total error within individual errors weighted
@@ -214,12 +336,11 @@ func getSubtreesAndAlphas(root, iterNode *DecisionNode) (*DecisionNode, *float64
 	// --> results also weighted
 	iterAsBranchR := 0.0
 	for _, l := range *iterLeaves {
-		leafErr, err := iterNode.Evaluator.GetErrorAtNode(&l)
+		leafR, err := l.risk(root)
 		if err != nil {
 			return nil, nil, err
 		}
-		leafWeight := float64(l.TrainData.Size()) / float64(root.TrainData.Size())
-		iterAsBranchR += *leafErr * leafWeight
+		iterAsBranchR += leafR
 	}
 
 	// current g value (alpha) - compare with left and right children, and if its better
@@ -261,20 +382,46 @@ func getSubtreesAndAlphas(root, iterNode *DecisionNode) (*DecisionNode, *float64
 	return bestSubtree, &bestAlpha, nil
 }
 
+// risk is R(t), the node's error as if it were a leaf, weighted by the fraction of the root's
+// training data (by weight) that made it to the node. Classification errors are rates over
+// class-weighted rows, so the fraction includes class weights too.
+func (n *DecisionNode) risk(root *DecisionNode) (float64, error) {
+	asLeafErr, err := n.Evaluator.GetErrorAtNode(n)
+	if err != nil {
+		return 0, err
+	}
+	weight, rootWeight := n.TrainData.TotalWeight(), root.TrainData.TotalWeight()
+	if c, ok := n.Evaluator.(ClassificationEvaluator); ok {
+		weight, rootWeight = c.totalWeight(n.TrainData), c.totalWeight(root.TrainData)
+	}
+	return *asLeafErr * weight / rootWeight, nil
+}
+
 // Predict returns this node's prediction for this vector of features
 func (n *DecisionNode) Predict(row dataset.Row) (*float64, error) {
+	leaf, err := n.Leaf(row)
+	if err != nil {
+		return nil, err
+	}
+
+	out := leaf.prediction()
+	return &out, nil
+}
+
+// Leaf returns the node that row ends up in when traversing the tree starting at n. This is
+// the node whose prediction Predict returns.
+func (n *DecisionNode) Leaf(row dataset.Row) (*DecisionNode, error) {
 	expectedNumCols := len(n.TrainData.Rows[0]) - 1
 	if len(row) != expectedNumCols {
 		return nil, fmt.Errorf("could not predict, expected %d columns, had %d", expectedNumCols, len(row))
 	}
 
 	if n.Partition == nil { // base case 1 - there are no parititions at all (leaf)
-		out := n.Evaluator.Predict(n)
-		return &out, nil
+		return n, nil
 	}
 
 	var nextChild *DecisionNode
-	if n.Partition.EvaluateRow(row) {
+	if n.evaluateRow(row) {
 		nextChild = n.R
 	} else {
 		nextChild = n.L
@@ -284,11 +431,34 @@ func (n *DecisionNode) Predict(row dataset.Row) (*float64, error) {
 	// _could_ split the data, but hasn't yet (we're probably at the root). Just run the
 	// whole node through the evaluator
 	if nextChild == nil {
-		out := n.Evaluator.Predict(n)
-		return &out, nil
+		return n, nil
+	}
+
+	return nextChild.Leaf(row)
+}
+
+// prediction returns Value if it has been set, and asks the evaluator otherwise
+func (n *DecisionNode) prediction() float64 {
+	if n.Value != nil {
+		return *n.Value
 	}
+	return n.Evaluator.Predict(n)
+}
 
-	return nextChild.Predict(row)
+// CacheLeafValues sets the Value of every leaf, starting at n, to its evaluator's prediction, so
+// that predicting doesn't go back over the training rows of the leaf each time
+func (n *DecisionNode) CacheLeafValues() {
+	if n.L == nil && n.R == nil {
+		value := n.Evaluator.Predict(n)
+		n.Value = &value
+		return
+	}
+	if n.L != nil {
+		n.L.CacheLeafValues()
+	}
+	if n.R != nil {
+		n.R.CacheLeafValues()
+	}
 }
 
 // getLeaves returns the leaves, starting at n. Note that the leaves
@@ -324,6 +494,11 @@ func (n *DecisionNode) DeepClone() *DecisionNode {
 	if n.Partition != nil {
 		curr.Partition = n.Partition
 	}
+	if n.Value != nil {
+		value := *n.Value
+		curr.Value = &value
+	}
+	curr.Surrogates = n.Surrogates
 
 	if n.L != nil {
 		l := n.L.DeepClone()
@@ -344,14 +519,22 @@ func (n *DecisionNode) print(name string, level int) {
 	tabs := strings.Repeat("\t", level)
 	logrus.Infof("%vname: %v", tabs, name)
 	if n.Partition != nil {
-		logrus.Infof("%spartition: col=%v val=%v", tabs, n.Partition.ColumnName, n.Partition.Value)
+		logrus.Infof("%spartition: %s", tabs, describePartition(n.Partition))
 	} else {
 		logrus.Infof("%spartition: <nil>", tabs)
 	}
+	for _, surrogate := range n.Surrogates {
+		logrus.Infof("%ssurrogate: %s reversed=%v agreement=%f", tabs, describePartition(surrogate.Partition), surrogate.Reversed, surrogate.Agreement)
+	}
 	// logrus.Infof("%vtrain data: %v", tabs, n.TrainData)
-	logrus.Infof("%vprediction: %v", tabs, n.Evaluator.Predict(n))
+	logrus.Infof("%vprediction: %v", tabs, n.prediction())
 	logrus.Infof("%vnum leaf: %d", tabs, n.TrainData.Size())
-	logrus.Infof("%simpurity: %f", tabs, n.TrainData.GiniImpurity())
+	if measure, ok := n.Evaluator.(ImpurityMeasure); ok {
+		name, impurity := measure.Impurity(n.TrainData)
+		logrus.Infof("%simpurity (%s): %f", tabs, name, impurity)
+	} else {
+		logrus.Infof("%simpurity: %f", tabs, n.TrainData.GiniImpurity())
+	}
 	logrus.Info()
 
 	if n.L != nil {
@@ -361,3 +544,12 @@ func (n *DecisionNode) print(name string, level int) {
 		n.R.print("R", level+1)
 	}
 }
+
+// describePartition returns the column of p, the categories or value that it splits on, and the
+// side that missing values go to, the way print shows them
+func describePartition(p *dataset.Partition) string {
+	if p.Categories != nil {
+		return fmt.Sprintf("col=%v in=%v missing=%v", p.ColumnName, p.Categories, p.MissingGoesTrue)
+	}
+	return fmt.Sprintf("col=%v val=%v missing=%v", p.ColumnName, p.Value, p.MissingGoesTrue)
+}
@@ -2,6 +2,8 @@ package decision_tree
 
 import (
 	"fmt"
+	"math"
+	"sort"
 
 	"robertkotcher.me/ML2022/dataset"
 )
@@ -21,108 +23,531 @@ type Evaluator interface {
 	IsBetter(newScore, oldScore float64) bool
 }
 
-// RegressionEvaluator helps us build a regression tree with continuous data
+// SplitAcceptor is implemented by evaluators whose scores can tell that a split isn't worth making
+// at all. A node becomes a leaf when its best split isn't accepted.
+type SplitAcceptor interface {
+	AcceptSplit(score float64) bool
+}
+
+// ImpurityMeasure is implemented by evaluators that score splits with an impurity measure. Print
+// shows each node's impurity with it.
+type ImpurityMeasure interface {
+	// Impurity returns the name of the measure, and its value for ds
+	Impurity(ds *dataset.Dataset) (string, float64)
+}
+
+// RegressionCriterion is the error that a RegressionEvaluator scores splits and leaves with
+type RegressionCriterion int
+
+const (
+	// SquaredError scores splits by their sum of squared residuals, and leaves predict the mean
+	SquaredError RegressionCriterion = iota
+	// AbsoluteError scores splits by their sum of absolute residuals, and leaves predict the
+	// median. It's less sensitive to outliers than SquaredError.
+	AbsoluteError
+	// FriedmanMSE scores splits by Friedman's improvement, n_L * n_R / (n_L + n_R) times the
+	// squared difference between the means of both sides, where bigger is better. Leaves predict
+	// the mean, and errors are squared residuals.
+	FriedmanMSE
+	// PoissonDeviance scores splits by the Poisson deviance of both sides, for non-negative count
+	// targets. Leaves predict the mean.
+	PoissonDeviance
+)
+
+// RegressionEvaluator helps us build a regression tree with continuous data. Criterion selects how
+// splits are scored, and defaults to SquaredError.
 type RegressionEvaluator struct {
+	Criterion RegressionCriterion
 }
 
-// EvaluateSplit evaluates the current regression split by taking the sum of squared residuals
+// EvaluateSplit evaluates the current regression split by the criterion's total error over both
+// sides, e.g. the sum of squared residuals
 func (r RegressionEvaluator) EvaluateSplit(dataset *dataset.Dataset, partition *dataset.Partition) (*float64, error) {
-	sumSquareResiduals := 0.0
+	node, err := r.statsOfDataset(dataset)
+	if err != nil {
+		return nil, err
+	}
+	falseStats, err := r.statsOfDataset(partition.False)
+	if err != nil {
+		return nil, err
+	}
+	trueStats, err := r.statsOfDataset(partition.True)
+	if err != nil {
+		return nil, err
+	}
+	return r.EvaluateStats(node, falseStats, trueStats)
+}
 
-	falseAvg := 0.0
-	for _, row := range partition.False.Rows {
-		falseAvg = row[len(row)-1]
+// statsOfDataset returns the statistics of every row in ds
+func (r RegressionEvaluator) statsOfDataset(ds *dataset.Dataset) (SplitStats, error) {
+	stats, err := r.NewStats(ds)
+	if err != nil {
+		return nil, err
 	}
-	falseAvg = falseAvg / float64(partition.False.Size())
-	for _, row := range partition.False.Rows {
-		residual := (row[len(row)-1] - falseAvg)
-		sumSquareResiduals += (residual * residual)
+	for i, row := range ds.Rows {
+		stats.Add(row.Y(), ds.Weight(i))
 	}
+	return stats, nil
+}
 
-	trueAvg := 0.0
-	for _, row := range partition.True.Rows {
-		trueAvg = row[len(row)-1]
+// NewStats returns the total weight, sum, sum of squares and sum of y * log(y) of targets, which is all
+// that's needed to find squared error and Poisson deviance. Absolute error also keeps the targets
+// in a medianTree, to find their median.
+func (r RegressionEvaluator) NewStats(ds *dataset.Dataset) (SplitStats, error) {
+	stats := regressionStats{}
+	if r.Criterion == AbsoluteError {
+		stats.targets = &medianTree{}
 	}
-	trueAvg = trueAvg / float64(partition.True.Size())
-	for _, row := range partition.True.Rows {
-		residual := (row[len(row)-1] - trueAvg)
-		sumSquareResiduals += (residual * residual)
+	return &stats, nil
+}
+
+// EvaluateStats scores a split like EvaluateSplit
+func (r RegressionEvaluator) EvaluateStats(node, falseStats, trueStats SplitStats) (*float64, error) {
+	L, R := falseStats.(*regressionStats), trueStats.(*regressionStats)
+
+	var score float64
+	switch r.Criterion {
+	case AbsoluteError:
+		score = L.sumAbsoluteResiduals() + R.sumAbsoluteResiduals()
+	case FriedmanMSE:
+		if L.weight > 0 && R.weight > 0 {
+			difference := L.sum/L.weight - R.sum/R.weight
+			score = L.weight * R.weight / (L.weight + R.weight) * difference * difference
+		}
+	case PoissonDeviance:
+		if L.negatives > 0 || R.negatives > 0 {
+			return nil, fmt.Errorf("poisson deviance requires non-negative targets")
+		}
+		score = L.poissonDeviance() + R.poissonDeviance()
+	default:
+		score = L.sumSquaredResiduals() + R.sumSquaredResiduals()
 	}
+	return &score, nil
+}
+
+// regressionStats are the SplitStats of a RegressionEvaluator. Every sum is weighted by each row's
+// weight, so a row with weight 2 counts the same as two copies of it.
+type regressionStats struct {
+	weight     float64
+	sum        float64
+	sumSquares float64
+	// sumYLogY is the sum of y * log(y), and negatives counts the targets where that's undefined
+	sumYLogY  float64
+	negatives int
+	// targets is only kept for AbsoluteError, and is nil otherwise
+	targets *medianTree
+}
+
+// weightedTarget is a row's target and weight
+type weightedTarget struct {
+	value  float64
+	weight float64
+}
+
+func (s *regressionStats) Add(target, weight float64) {
+	s.weight += weight
+	s.sum += weight * target
+	s.sumSquares += weight * target * target
+	s.sumYLogY += weight * yLogY(target)
+	if target < 0 {
+		s.negatives++
+	}
+	if s.targets != nil {
+		s.targets.add(target, weight, 1)
+	}
+}
+
+func (s *regressionStats) Remove(target, weight float64) {
+	s.weight -= weight
+	s.sum -= weight * target
+	s.sumSquares -= weight * target * target
+	s.sumYLogY -= weight * yLogY(target)
+	if target < 0 {
+		s.negatives--
+	}
+	if s.targets != nil {
+		s.targets.remove(target, weight, 1)
+	}
+}
 
-	return &sumSquareResiduals, nil
+func (s *regressionStats) AddStats(other SplitStats) {
+	o := other.(*regressionStats)
+	s.weight += o.weight
+	s.sum += o.sum
+	s.sumSquares += o.sumSquares
+	s.sumYLogY += o.sumYLogY
+	s.negatives += o.negatives
+	if s.targets != nil {
+		s.targets.addTree(o.targets)
+	}
 }
 
-// GetErrorAtNode evaluates the sum of squared residuals for regression node
+func (s *regressionStats) RemoveStats(other SplitStats) {
+	o := other.(*regressionStats)
+	s.weight -= o.weight
+	s.sum -= o.sum
+	s.sumSquares -= o.sumSquares
+	s.sumYLogY -= o.sumYLogY
+	s.negatives -= o.negatives
+	if s.targets != nil {
+		s.targets.removeTree(o.targets)
+	}
+}
+
+// sumSquaredResiduals is sum(w * y^2) - sum(w * y)^2 / sum(w), which can't be negative (except by
+// rounding)
+func (s *regressionStats) sumSquaredResiduals() float64 {
+	if s.weight <= 0 {
+		return 0
+	}
+	return math.Max(0, s.sumSquares-s.sum*s.sum/s.weight)
+}
+
+// sumAbsoluteResiduals is the weighted sum of absolute differences between each target and the
+// weighted median
+func (s *regressionStats) sumAbsoluteResiduals() float64 {
+	return s.targets.sumAbsoluteResiduals()
+}
+
+// poissonDeviance is 2 * sum(w * (y * log(y / mean) - (y - mean))). The second term sums to 0
+// around the mean, which leaves 2 * (sum(w * y * log(y)) - sum(w * y) * log(mean)).
+func (s *regressionStats) poissonDeviance() float64 {
+	if s.sum <= 0 {
+		return 0
+	}
+	return math.Max(0, 2*(s.sumYLogY-s.sum*math.Log(s.sum/s.weight)))
+}
+
+// yLogY is y * log(y), which goes to 0 as y goes to 0
+func yLogY(y float64) float64 {
+	if y <= 0 {
+		return 0
+	}
+	return y * math.Log(y)
+}
+
+// weightedMedian returns the value that splits the weight of sorted targets in half, or 0 when
+// there are none. When a target ends exactly half of the weight, the median is the average of it
+// and the next one, so equal weights give the usual median.
+func weightedMedian(targets []weightedTarget) float64 {
+	total := 0.0
+	for _, target := range targets {
+		total += target.weight
+	}
+
+	cumulative := 0.0
+	for i, target := range targets {
+		cumulative += target.weight
+		if cumulative >= total/2 {
+			if cumulative == total/2 && i+1 < len(targets) {
+				return (target.value + targets[i+1].value) / 2
+			}
+			return target.value
+		}
+	}
+	return 0
+}
+
+// GetErrorAtNode evaluates the criterion's total (weighted) error for regression node, as if it
+// were a leaf, e.g. its sum of squared residuals
 func (r RegressionEvaluator) GetErrorAtNode(node *DecisionNode) (*float64, error) {
+	pred := node.prediction()
+
 	totalError := 0.0
-	for _, row := range node.TrainData.Rows {
-		pred, err := node.Predict(row.X())
-		if err != nil {
-			return nil, err
-		}
-		totalError += r.GetSingleError(row.Y(), *pred)
+	for i, row := range node.TrainData.Rows {
+		totalError += node.TrainData.Weight(i) * r.GetSingleError(row.Y(), pred)
 	}
 	return &totalError, nil
 }
 
-// GetSingleError returns the squared residual
+// GetSingleError returns the squared residual, the absolute residual for AbsoluteError, or the
+// Poisson deviance of a single prediction for PoissonDeviance
 func (r RegressionEvaluator) GetSingleError(actual, predicted float64) float64 {
+	switch r.Criterion {
+	case AbsoluteError:
+		return math.Abs(actual - predicted)
+	case PoissonDeviance:
+		// a node can only predict 0 if all of its targets are 0
+		predicted = math.Max(predicted, minHessian)
+		return 2 * (yLogY(actual) - actual*math.Log(predicted) - (actual - predicted))
+	}
 	return (actual - predicted) * (actual - predicted)
 }
 
-// Predict returns the average value for data points at this node
+// Predict returns the (weighted) average value for data points at this node, or the weighted
+// median for AbsoluteError
 func (r RegressionEvaluator) Predict(node *DecisionNode) float64 {
+	if r.Criterion == AbsoluteError {
+		targets := make([]weightedTarget, node.TrainData.Size())
+		for i, dp := range node.TrainData.Rows {
+			targets[i] = weightedTarget{value: dp.Y(), weight: node.TrainData.Weight(i)}
+		}
+		sort.SliceStable(targets, func(i, j int) bool { return targets[i].value < targets[j].value })
+		return weightedMedian(targets)
+	}
+
 	total := 0.0
-	for _, dp := range node.TrainData.Rows {
-		total += dp.Y()
+	for i, dp := range node.TrainData.Rows {
+		total += node.TrainData.Weight(i) * dp.Y()
 	}
-	return total / float64(node.TrainData.Size())
+	return total / node.TrainData.TotalWeight()
 }
 
+// IsBetter returns true for lower errors, or for bigger improvements with FriedmanMSE
 func (r RegressionEvaluator) IsBetter(newScore, oldScore float64) bool {
+	if r.Criterion == FriedmanMSE {
+		return newScore > oldScore
+	}
 	return newScore < oldScore
 }
 
-// ClassificationEvaluator helps us build a decision tree for classification
+// ClassificationCriterion is the impurity measure that a ClassificationEvaluator scores splits with
+type ClassificationCriterion int
+
+const (
+	// Gini scores splits by how much they lower Gini impurity
+	Gini ClassificationCriterion = iota
+	// Entropy scores splits by information gain, i.e. how much they lower Shannon entropy
+	Entropy
+	// GainRatio is C4.5's gain ratio: information gain divided by the entropy of the split's
+	// sizes. This keeps splits that cut the data into tiny pieces from looking better than they are.
+	GainRatio
+)
+
+func (c ClassificationCriterion) String() string {
+	switch c {
+	case Entropy:
+		return "entropy"
+	case GainRatio:
+		return "gain ratio"
+	}
+	return "gini"
+}
+
+// ClassificationEvaluator helps us build a decision tree for classification. Criterion selects how
+// splits are scored, and defaults to Gini.
+//
+// ClassWeights scales the weight of every row by the weight of its class (keyed by enum value), so
+// that rare classes aren't drowned out by common ones. Classes missing from the map have weight 1.
+// BalancedClassWeights returns weights that give every class the same total weight.
 type ClassificationEvaluator struct {
+	Criterion    ClassificationCriterion
+	ClassWeights map[float64]float64
 }
 
-// EvaluateSplit for classification currently only supports information gain metric. An InfoGain of 1
-// would mean that this partition perfectly divides the data (bad), and an InfoGain of 0
-// would mean we haven't learned anything new from this split (also bad).
+// BalancedClassWeights returns class weights for ClassificationEvaluator that give every class in
+// ds the same total weight, i.e. total weight / (number of classes * weight of the class)
+func BalancedClassWeights(ds *dataset.Dataset) (map[float64]float64, error) {
+	if ds.Size() == 0 {
+		return nil, fmt.Errorf("cannot balance classes of an empty dataset")
+	}
+
+	classTotals := map[float64]float64{}
+	for i, row := range ds.Rows {
+		classTotals[row.Y()] += ds.Weight(i)
+	}
+
+	weights := map[float64]float64{}
+	for class, classTotal := range classTotals {
+		weights[class] = ds.TotalWeight() / (float64(len(classTotals)) * classTotal)
+	}
+	return weights, nil
+}
+
+// EvaluateSplit returns how much the partition lowers impurity, i.e. the parent's impurity less the
+// (weighted) average impurity of both sides. With Entropy this is information gain. A gain of 0
+// means we haven't learned anything new from this split. GainRatio divides information gain by the
+// entropy of the split's sizes.
 func (c ClassificationEvaluator) EvaluateSplit(dataset *dataset.Dataset, partition *dataset.Partition) (*float64, error) {
-	nCols := len(dataset.ColumnIsContinuous)
-	if dataset.ColumnIsContinuous[nCols-1] {
-		return nil, fmt.Errorf("target labels must not be continuous for classification")
+	node, err := c.statsOfDataset(dataset)
+	if err != nil {
+		return nil, err
+	}
+	falseStats, err := c.statsOfDataset(partition.False)
+	if err != nil {
+		return nil, err
+	}
+	trueStats, err := c.statsOfDataset(partition.True)
+	if err != nil {
+		return nil, err
 	}
+	return c.EvaluateStats(node, falseStats, trueStats)
+}
 
-	SImpurity := dataset.GiniImpurity()
-	SSize := float64(dataset.Size())
+// Impurity returns the name and value of the impurity measure that the evaluator's criterion uses
+// for ds
+func (c ClassificationEvaluator) Impurity(ds *dataset.Dataset) (string, float64) {
+	name := c.Criterion.String()
+	if c.Criterion == GainRatio {
+		// gain ratio is still based on entropy
+		name = Entropy.String()
+	}
 
-	// left (false) impurity and dataset size
-	LImpurity := partition.False.GiniImpurity()
-	LSize := float64(partition.False.Size())
+	stats, err := c.statsOfDataset(ds)
+	if err != nil {
+		return name, math.NaN()
+	}
+	return name, stats.(*classStats).impurity(c.Criterion)
+}
+
+// statsOfDataset returns the statistics of every row in ds
+func (c ClassificationEvaluator) statsOfDataset(ds *dataset.Dataset) (SplitStats, error) {
+	stats, err := c.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range ds.Rows {
+		stats.Add(row.Y(), ds.Weight(i))
+	}
+	return stats, nil
+}
+
+// classWeight returns the weight of class from ClassWeights, or 1
+func (c ClassificationEvaluator) classWeight(class float64) float64 {
+	if weight, ok := c.ClassWeights[class]; ok {
+		return weight
+	}
+	return 1
+}
+
+// totalWeight returns the total weight of the rows of ds, including class weights
+func (c ClassificationEvaluator) totalWeight(ds *dataset.Dataset) float64 {
+	total := 0.0
+	for i, row := range ds.Rows {
+		total += ds.Weight(i) * c.classWeight(row.Y())
+	}
+	return total
+}
+
+// NewStats returns the weight of each class, which is all that's needed to find impurity
+func (c ClassificationEvaluator) NewStats(ds *dataset.Dataset) (SplitStats, error) {
+	nCols := len(ds.ColumnIsContinuous)
+	if ds.ColumnIsContinuous[nCols-1] {
+		return nil, fmt.Errorf("target labels must not be continuous for classification")
+	}
+	return &classStats{evaluator: c, weights: map[float64]float64{}}, nil
+}
 
-	// right (true) impurity and dataset size
-	RImpurity := partition.True.GiniImpurity()
-	RSize := float64(partition.True.Size())
+// EvaluateStats returns how much a split lowers impurity, like EvaluateSplit
+func (c ClassificationEvaluator) EvaluateStats(node, falseStats, trueStats SplitStats) (*float64, error) {
+	S, L, R := node.(*classStats), falseStats.(*classStats), trueStats.(*classStats)
 
-	avgImpurity := ((RSize / SSize) * RImpurity) + ((LSize / SSize) * LImpurity)
-	infoGain := SImpurity - avgImpurity
+	avgImpurity := ((R.total / S.total) * R.impurity(c.Criterion)) + ((L.total / S.total) * L.impurity(c.Criterion))
+	infoGain := S.impurity(c.Criterion) - avgImpurity
 
+	if c.Criterion == GainRatio {
+		infoGain = gainRatio(infoGain, L.total, R.total)
+	}
 	return &infoGain, nil
 }
 
-// GetErrorAtNode is the total number of misclassified data points at this node divided
-// by the total data points that reached this node during training
+// gainRatio divides information gain by the split information, i.e. the entropy of the sizes of
+// both sides. Splits that leave one side empty have no split information, and a ratio of 0.
+func gainRatio(infoGain, LSize, RSize float64) float64 {
+	splitInfo := 0.0
+	for _, size := range []float64{LSize, RSize} {
+		if size > 0 {
+			ratio := size / (LSize + RSize)
+			splitInfo -= ratio * math.Log2(ratio)
+		}
+	}
+	if splitInfo == 0 {
+		return 0
+	}
+	return infoGain / splitInfo
+}
+
+// classStats are the SplitStats of a ClassificationEvaluator. weights[label] is the weight of the
+// class with that label, scaled by its class weight.
+type classStats struct {
+	evaluator ClassificationEvaluator
+	weights   map[float64]float64
+	total     float64
+}
+
+func (s *classStats) Add(target, weight float64) {
+	weight *= s.evaluator.classWeight(target)
+	s.weights[target] += weight
+	s.total += weight
+}
+
+func (s *classStats) Remove(target, weight float64) {
+	weight *= s.evaluator.classWeight(target)
+	s.weights[target] -= weight
+	s.total -= weight
+}
+
+func (s *classStats) AddStats(other SplitStats) {
+	o := other.(*classStats)
+	for class, weight := range o.weights {
+		s.weights[class] += weight
+	}
+	s.total += o.total
+}
+
+func (s *classStats) RemoveStats(other SplitStats) {
+	o := other.(*classStats)
+	for class, weight := range o.weights {
+		s.weights[class] -= weight
+	}
+	s.total -= o.total
+}
+
+// sortedWeights returns the weight of each class, in order of their labels, so that impurity sums
+// them in the same order every time
+func (s *classStats) sortedWeights() []float64 {
+	classes := make([]float64, 0, len(s.weights))
+	for class := range s.weights {
+		classes = append(classes, class)
+	}
+	sort.Float64s(classes)
+
+	weights := make([]float64, len(classes))
+	for i, class := range classes {
+		weights[i] = s.weights[class]
+	}
+	return weights
+}
+
+// impurity matches Dataset.GiniImpurity, or Dataset.Entropy for criteria based on entropy, for the
+// same rows (without class weights)
+func (s *classStats) impurity(criterion ClassificationCriterion) float64 {
+	if criterion == Gini {
+		impurity := 1.0
+		if s.total <= 0 {
+			return impurity
+		}
+		for _, weight := range s.sortedWeights() {
+			ratio := weight / s.total
+			impurity -= (ratio * ratio)
+		}
+		return impurity
+	}
+
+	entropy := 0.0
+	for _, weight := range s.sortedWeights() {
+		if weight > 0 && s.total > 0 {
+			ratio := weight / s.total
+			entropy -= ratio * math.Log2(ratio)
+		}
+	}
+	return entropy
+}
+
+// GetErrorAtNode is the total weight of misclassified data points at this node divided
+// by the total weight of data points that reached this node during training. Weights include
+// class weights.
 func (c ClassificationEvaluator) GetErrorAtNode(node *DecisionNode) (*float64, error) {
 	nodeClass := node.Evaluator.Predict(node)
 
 	totalError := 0.0
-	for _, row := range node.TrainData.Rows {
-		totalError += c.GetSingleError(row.Y(), nodeClass)
+	totalWeight := 0.0
+	for i, row := range node.TrainData.Rows {
+		weight := node.TrainData.Weight(i) * c.classWeight(row.Y())
+		totalError += weight * c.GetSingleError(row.Y(), nodeClass)
+		totalWeight += weight
 	}
-	totalError = totalError / float64(node.TrainData.Size())
+	totalError = totalError / totalWeight
 	return &totalError, nil
 }
 
@@ -134,13 +559,13 @@ func (c ClassificationEvaluator) GetSingleError(actual, predicted float64) float
 	return 1
 }
 
-// Predict returns the class with the largest representation
+// Predict returns the class with the largest representation (by weight, including class weights)
 func (c ClassificationEvaluator) Predict(node *DecisionNode) float64 {
-	counts := map[float64]int{}
+	counts := map[float64]float64{}
 	var bestClass float64
-	var bestCount int
-	for _, r := range node.TrainData.Rows {
-		counts[r.Y()] += 1
+	var bestCount float64
+	for i, r := range node.TrainData.Rows {
+		counts[r.Y()] += node.TrainData.Weight(i) * c.classWeight(r.Y())
 		if counts[r.Y()] > bestCount {
 			bestClass = r.Y()
 			bestCount = counts[r.Y()]
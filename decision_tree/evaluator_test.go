@@ -0,0 +1,241 @@
+package decision_tree
+
+import (
+	"math"
+	"testing"
+
+	"robertkotcher.me/ML2022/dataset"
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+func TestRegressionCriteria(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "y"},
+		[]bool{true, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for _, y := range []float64{1, 2, 3, 100} {
+		ds.InsertRow(dataset.Row{0, y})
+	}
+
+	// a single leaf predicts the median with absolute error, so the outlier doesn't pull it up
+	leaf, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{Criterion: AbsoluteError}, BuildOptions{MaxDepth: ptr.PointToInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred, err := leaf.Predict(dataset.Row{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *pred != 2.5 {
+		t.Errorf("expected the median 2.5, got %v", *pred)
+	}
+	nodeError, err := leaf.Evaluator.GetErrorAtNode(leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *nodeError != 1.5+0.5+0.5+97.5 {
+		t.Errorf("expected a total absolute error of 100, got %v", *nodeError)
+	}
+
+	ds.InsertRow(dataset.Row{1, -1})
+	if _, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{Criterion: PoissonDeviance}, BuildOptions{}); err == nil {
+		t.Error("expected poisson deviance to reject negative targets")
+	}
+}
+
+func TestWeightsMatchDuplicatedRows(t *testing.T) {
+	names := []string{"x", "y"}
+	continuous := []bool{true, true}
+	weighted := dataset.NewDataset(names, continuous, []dataset.Row{}, &dataset.EnumMapper{})
+	duplicated := dataset.NewDataset(names, continuous, []dataset.Row{}, &dataset.EnumMapper{})
+	for x := 0.0; x < 12; x++ {
+		row := dataset.Row{x, x*x - 3*x}
+		copies := 1 + int(x)%3
+		weighted.InsertWeightedRow(row, float64(copies))
+		for i := 0; i < copies; i++ {
+			duplicated.InsertRow(row)
+		}
+	}
+
+	for _, evaluator := range []Evaluator{RegressionEvaluator{}, RegressionEvaluator{Criterion: AbsoluteError}} {
+		a, err := BuildTreeWithOverfitting(weighted, evaluator, BuildOptions{MaxDepth: ptr.PointToInt(4)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := BuildTreeWithOverfitting(duplicated, evaluator, BuildOptions{MaxDepth: ptr.PointToInt(4)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameSplits(a, b) {
+			t.Fatal("expected weighted rows to build the same tree as duplicated rows")
+		}
+
+		_, weightedAlphas, err := a.GetSubtreesAndAlphas()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, duplicatedAlphas, err := b.GetSubtreesAndAlphas()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*weightedAlphas) != len(*duplicatedAlphas) {
+			t.Fatalf("expected %d alphas, got %d", len(*duplicatedAlphas), len(*weightedAlphas))
+		}
+		for i := range *weightedAlphas {
+			if math.Abs((*weightedAlphas)[i]-(*duplicatedAlphas)[i]) > 1e-9 {
+				t.Errorf("expected alpha %v, got %v", (*duplicatedAlphas)[i], (*weightedAlphas)[i])
+			}
+		}
+	}
+}
+
+func TestClassWeights(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "fraud"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{"fraud": {"no", "yes"}},
+	)
+	for x := 0.0; x < 50; x++ {
+		fraud := 0.0
+		if x == 10 || x == 30 {
+			fraud = 1
+		}
+		ds.InsertRow(dataset.Row{x, fraud})
+	}
+
+	balanced, err := BalancedClassWeights(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(balanced[0]-50.0/96) > 1e-9 || math.Abs(balanced[1]-12.5) > 1e-9 {
+		t.Errorf("expected balanced weights 50/96 and 12.5, got %v", balanced)
+	}
+
+	options := BuildOptions{MaxDepth: ptr.PointToInt(1)}
+	for _, test := range []struct {
+		evaluator ClassificationEvaluator
+		expected  float64
+	}{
+		{ClassificationEvaluator{}, 0},
+		{ClassificationEvaluator{ClassWeights: map[float64]float64{1: 30}}, 1},
+	} {
+		leaf, err := BuildTreeWithOverfitting(ds, test.evaluator, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pred, err := leaf.Predict(dataset.Row{0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *pred != test.expected {
+			t.Errorf("expected class weights %v to predict %v, got %v", test.evaluator.ClassWeights, test.expected, *pred)
+		}
+	}
+
+	// balanced weights make both classes count the same, so the root is as impure as it gets
+	stump, err := BuildTreeWithOverfitting(ds, ClassificationEvaluator{ClassWeights: balanced}, BuildOptions{MaxDepth: ptr.PointToInt(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, impurity := stump.Evaluator.(ClassificationEvaluator).Impurity(ds)
+	if math.Abs(impurity-0.5) > 1e-9 {
+		t.Errorf("expected balanced classes to have gini impurity 0.5, got %v", impurity)
+	}
+	if stump.Partition == nil {
+		t.Fatal("expected the stump to split")
+	}
+
+	// R(T_t), the sum of R(t) of the leaves under a node, is the class-weighted error of the node's
+	// subtree on its rows, as a fraction of the class-weighted rows of the root
+	evaluator := ClassificationEvaluator{ClassWeights: balanced}
+	tree, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{MaxDepth: ptr.PointToInt(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var checkRisk func(node *DecisionNode)
+	checkRisk = func(node *DecisionNode) {
+		if node.Partition == nil {
+			return
+		}
+		leaves, err := node.getLeaves()
+		if err != nil {
+			t.Fatal(err)
+		}
+		leavesRisk := 0.0
+		for _, leaf := range *leaves {
+			r, err := leaf.risk(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leavesRisk += r
+		}
+		misclassified := 0.0
+		for i, row := range node.TrainData.Rows {
+			pred, err := node.Predict(row.X())
+			if err != nil {
+				t.Fatal(err)
+			}
+			misclassified += node.TrainData.Weight(i) * evaluator.classWeight(row.Y()) * evaluator.GetSingleError(row.Y(), *pred)
+		}
+		if expected := misclassified / evaluator.totalWeight(ds); math.Abs(leavesRisk-expected) > 1e-9 {
+			t.Errorf("expected the leaves under a node to have a risk of %v, got %v", expected, leavesRisk)
+		}
+		checkRisk(node.L)
+		checkRisk(node.R)
+	}
+	checkRisk(tree)
+
+	_, alphas, err := tree.GetSubtreesAndAlphas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alpha := range *alphas {
+		if alpha < 0 {
+			t.Errorf("expected every alpha to be non-negative, got %v", *alphas)
+			break
+		}
+	}
+}
+
+func TestClassificationLabels(t *testing.T) {
+	// labels don't have to be enum indices, so -1 and .5 are classes of their own
+	ds := dataset.NewDataset(
+		[]string{"x", "label"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	labels := []float64{-1, 0, .5, 1}
+	for x := 0.0; x < 20; x++ {
+		ds.InsertRow(dataset.Row{x, labels[int(x)/5]})
+	}
+
+	for _, criterion := range []ClassificationCriterion{Gini, Entropy} {
+		evaluator := ClassificationEvaluator{Criterion: criterion}
+		_, impurity := evaluator.Impurity(ds)
+		expected := ds.GiniImpurity()
+		if criterion == Entropy {
+			expected = ds.Entropy()
+		}
+		if math.Abs(impurity-expected) > 1e-9 {
+			t.Errorf("%v: expected impurity %v for 4 classes, got %v", criterion, expected, impurity)
+		}
+
+		tree, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range ds.Rows {
+			pred, err := tree.Predict(row.X())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *pred != row.Y() {
+				t.Errorf("%v: expected x = %v to predict %v, got %v", criterion, row[0], row.Y(), *pred)
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package decision_tree
+
+import (
+	"fmt"
+	"math"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// FitResult is a tree built and pruned by Fit, along with the cost-complexity pruning path that it
+// was picked from
+type FitResult struct {
+	// Tree is the tree grown on all of the data, pruned with Alpha
+	Tree *DecisionNode
+	// Alpha is the cost-complexity parameter that Tree was pruned with
+	Alpha float64
+	// Alphas is the pruning path of the tree grown on all of the data, and Subtrees[i] is its best
+	// subtree for alphas from Alphas[i] up to Alphas[i+1]. The first subtree is the whole tree, and
+	// the last is just the root.
+	Alphas   []float64
+	Subtrees []*DecisionNode
+	// CVScores[i] is the cross-validated error of pruning with Alphas[i], i.e. the (weighted)
+	// average error of each held-out row on the tree grown without it, and CVStandardErrors[i] is
+	// its standard error. Both are nil when Alpha was set by options.CCPAlpha.
+	CVScores         []float64
+	CVStandardErrors []float64
+}
+
+// AlphaSelection is how Fit picks an alpha from the cross-validated errors of a pruning path
+type AlphaSelection int
+
+const (
+	// MinimumCVError picks the alpha with the lowest cross-validated error
+	MinimumCVError AlphaSelection = iota
+	// OneStandardError picks the largest alpha (i.e. the smallest tree) whose cross-validated error
+	// is within one standard error of the lowest one, like CART
+	OneStandardError
+)
+
+// Fit grows a tree on ds and prunes it with cost-complexity pruning. The tree is pruned with
+// options.CCPAlpha when it's set. Otherwise an alpha is picked from the pruning path by its 10-fold
+// cross-validated error, as options.AlphaSelection says, preferring larger alphas (and smaller
+// trees) on ties. Rows are shuffled into folds with options.Rand when it's set. ds isn't modified.
+func Fit(ds *dataset.Dataset, evaluator Evaluator, options BuildOptions) (*FitResult, error) {
+	tree, err := BuildTreeWithOverfitting(ds, evaluator, options)
+	if err != nil {
+		return nil, err
+	}
+	subtrees, alphas, err := pruningPath(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	result := FitResult{Alphas: alphas, Subtrees: subtrees}
+	if options.CCPAlpha != nil {
+		if *options.CCPAlpha < 0 {
+			return nil, fmt.Errorf("cannot prune with a negative alpha %v", *options.CCPAlpha)
+		}
+		result.Alpha = *options.CCPAlpha
+		result.Tree = subtrees[subtreeIndexForAlpha(alphas, result.Alpha)]
+		return &result, nil
+	}
+
+	result.CVScores, result.CVStandardErrors, err = crossValidateAlphas(alphas, ds, evaluator, options)
+	if err != nil {
+		return nil, err
+	}
+	best, err := selectAlpha(result.CVScores, result.CVStandardErrors, options.AlphaSelection)
+	if err != nil {
+		return nil, err
+	}
+	result.Alpha, result.Tree = alphas[best], subtrees[best]
+	return &result, nil
+}
+
+// selectAlpha returns the index of the alpha that selection picks, given the cross-validated
+// errors of a pruning path (in order of increasing alpha) and their standard errors
+func selectAlpha(scores, standardErrors []float64, selection AlphaSelection) (int, error) {
+	if len(scores) == 0 {
+		return 0, fmt.Errorf("cannot select an alpha without any scores")
+	}
+	if len(standardErrors) != len(scores) {
+		return 0, fmt.Errorf("expected a standard error for each of %d scores, had %d", len(scores), len(standardErrors))
+	}
+
+	best := 0
+	for i, score := range scores {
+		if score <= scores[best] {
+			best = i
+		}
+	}
+
+	switch selection {
+	case MinimumCVError:
+		return best, nil
+	case OneStandardError:
+		threshold := scores[best] + standardErrors[best]
+		for i := len(scores) - 1; i > best; i-- {
+			if scores[i] <= threshold {
+				return i, nil
+			}
+		}
+		return best, nil
+	default:
+		return 0, fmt.Errorf("unknown alpha selection %d", selection)
+	}
+}
+
+// pruningPath returns the subtrees and alphas of GetSubtreesAndAlphas as slices of pointers
+func pruningPath(tree *DecisionNode) ([]*DecisionNode, []float64, error) {
+	subtrees, alphas, err := tree.GetSubtreesAndAlphas()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := make([]*DecisionNode, len(*subtrees))
+	for i := range *subtrees {
+		path[i] = &(*subtrees)[i]
+	}
+	return path, *alphas, nil
+}
+
+// subtreeIndexForAlpha returns the index of the best subtree for alpha on a pruning path, i.e. the
+// last one whose alpha isn't larger
+func subtreeIndexForAlpha(alphas []float64, alpha float64) int {
+	index := 0
+	for i, a := range alphas {
+		if a <= alpha {
+			index = i
+		}
+	}
+	return index
+}
+
+// crossValidateAlphas returns the 10-fold cross-validated error of pruning with each of alphas,
+// and its standard error. Like CART, each fold's tree is pruned with the geometric mean of an alpha
+// and the next one, which is the middle of the range that the alpha's subtree is best for, and
+// the standard error is that of the mean of the held-out rows' errors.
+func crossValidateAlphas(alphas []float64, ds *dataset.Dataset, evaluator Evaluator, options BuildOptions) ([]float64, []float64, error) {
+	if len(alphas) == 0 {
+		return nil, nil, fmt.Errorf("cannot cross-validate an empty list of alphas")
+	}
+	if ds.Size() < 10 {
+		return nil, nil, fmt.Errorf("cross-validation requires at least 10 rows, had %d", ds.Size())
+	}
+
+	shuffled := shuffledCopy(ds, options)
+	trainsets, testsets, err := shuffled.CrossValidationSets()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the (weighted) sums of each held-out row's error, and of its square
+	scores := make([]float64, len(alphas))
+	squares := make([]float64, len(alphas))
+	totalWeight, numRows := 0.0, 0
+	for f := range trainsets {
+		tree, err := BuildTreeWithOverfitting(&trainsets[f], evaluator, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		foldSubtrees, foldAlphas, err := pruningPath(tree)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		testset := testsets[f]
+		totalWeight += testset.TotalWeight()
+		numRows += testset.Size()
+		for i := range alphas {
+			alpha := alphas[i]
+			if i+1 < len(alphas) {
+				alpha = math.Sqrt(alphas[i] * alphas[i+1])
+			}
+			subtree := foldSubtrees[subtreeIndexForAlpha(foldAlphas, alpha)]
+
+			for r, testrow := range testset.Rows {
+				pred, err := subtree.Predict(testrow.X())
+				if err != nil {
+					return nil, nil, err
+				}
+				rowErr := evaluator.GetSingleError(testrow.Y(), *pred)
+				scores[i] += testset.Weight(r) * rowErr
+				squares[i] += testset.Weight(r) * rowErr * rowErr
+			}
+		}
+	}
+
+	standardErrors := make([]float64, len(alphas))
+	for i := range scores {
+		scores[i] /= totalWeight
+		variance := math.Max(squares[i]/totalWeight-scores[i]*scores[i], 0)
+		standardErrors[i] = math.Sqrt(variance / float64(numRows))
+	}
+	return scores, standardErrors, nil
+}
+
+// shuffledCopy returns the rows of ds in a random order, drawn from options.Rand when it's set
+func shuffledCopy(ds *dataset.Dataset, options BuildOptions) *dataset.Dataset {
+	if options.Rand != nil {
+		return ds.Subset(options.Rand.Perm(ds.Size()))
+	}
+
+	indices := make([]int, ds.Size())
+	for i := range indices {
+		indices[i] = i
+	}
+	shuffled := ds.Subset(indices)
+	shuffled.Shuffle()
+	return shuffled
+}
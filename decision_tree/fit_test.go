@@ -0,0 +1,142 @@
+package decision_tree
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+func TestFitWithFixedAlpha(t *testing.T) {
+	ds := buildSplitDataset(false)
+
+	full, err := Fit(ds, RegressionEvaluator{}, BuildOptions{CCPAlpha: ptr.PointToFloat(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.CVScores != nil {
+		t.Errorf("expected no cross-validation with a fixed alpha")
+	}
+	if full.Tree != full.Subtrees[0] || len(full.Alphas) != len(full.Subtrees) {
+		t.Errorf("expected an alpha of 0 to keep the whole tree")
+	}
+
+	stump, err := Fit(ds, RegressionEvaluator{}, BuildOptions{CCPAlpha: ptr.PointToFloat(math.Inf(1))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stump.Tree.L != nil || stump.Tree.R != nil {
+		t.Errorf("expected an infinite alpha to prune the tree to its root")
+	}
+
+	// a fixed alpha picks the subtree whose range of alphas it falls in
+	middle := len(full.Alphas) / 2
+	alpha := (full.Alphas[middle] + full.Alphas[middle+1]) / 2
+	pruned, err := Fit(ds, RegressionEvaluator{}, BuildOptions{CCPAlpha: &alpha})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSplits(pruned.Tree, pruned.Subtrees[middle]) {
+		t.Errorf("expected alpha %v to pick subtree %d", alpha, middle)
+	}
+
+	if _, err := Fit(ds, RegressionEvaluator{}, BuildOptions{CCPAlpha: ptr.PointToFloat(-1)}); err == nil {
+		t.Errorf("expected an error for a negative alpha")
+	}
+}
+
+func TestFitWithCrossValidation(t *testing.T) {
+	ds := buildSplitDataset(false)
+	rows := append([]float64{}, ds.Rows[0]...)
+
+	fit := func() *FitResult {
+		result, err := Fit(ds, RegressionEvaluator{}, BuildOptions{Rand: rand.New(rand.NewSource(1))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	result := fit()
+
+	if len(result.CVScores) != len(result.Alphas) {
+		t.Fatalf("expected a score for each of %d alphas, got %d", len(result.Alphas), len(result.CVScores))
+	}
+	best := 0
+	for i, score := range result.CVScores {
+		if score <= result.CVScores[best] {
+			best = i
+		}
+	}
+	if result.Alpha != result.Alphas[best] || result.Tree != result.Subtrees[best] {
+		t.Errorf("expected the alpha with the lowest score %v, got %v", result.Alphas[best], result.Alpha)
+	}
+	// the targets are mostly explained by the features, so the root alone scores worst
+	if result.CVScores[len(result.CVScores)-1] <= result.CVScores[best] {
+		t.Errorf("expected pruning to the root to score worse than %v", result.CVScores[best])
+	}
+
+	for i, standardError := range result.CVStandardErrors {
+		if standardError <= 0 || standardError >= result.CVScores[i] {
+			t.Errorf("expected alpha %v to have a standard error between 0 and its score %v, got %v",
+				result.Alphas[i], result.CVScores[i], standardError)
+		}
+	}
+
+	// the one standard error rule only ever picks a smaller tree
+	oneSE, err := Fit(ds, RegressionEvaluator{}, BuildOptions{Rand: rand.New(rand.NewSource(1)), AlphaSelection: OneStandardError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(oneSE.CVScores, result.CVScores) || oneSE.Alpha < result.Alpha {
+		t.Errorf("expected the one standard error rule to pick an alpha of at least %v, got %v", result.Alpha, oneSE.Alpha)
+	}
+
+	// cross-validating the pruning path by hand gives the same error curve and alpha
+	index, scores, standardErrors, err := GetAlphaIndexFromCrossValidation(result.Alphas, ds, RegressionEvaluator{}, BuildOptions{Rand: rand.New(rand.NewSource(1))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scores, result.CVScores) || !reflect.DeepEqual(standardErrors, result.CVStandardErrors) || result.Alphas[*index] != result.Alpha {
+		t.Errorf("expected GetAlphaIndexFromCrossValidation to return the error curve and alpha of Fit")
+	}
+
+	if again := fit(); !reflect.DeepEqual(again.CVScores, result.CVScores) {
+		t.Errorf("expected the same Rand to score alphas the same way")
+	}
+	if !reflect.DeepEqual(rows, []float64(ds.Rows[0])) {
+		t.Errorf("expected Fit to leave the order of the rows alone")
+	}
+}
+
+func TestSelectAlpha(t *testing.T) {
+	scores := []float64{5, 3, 2, 2.5, 2.9, 3.1, 6}
+	standardErrors := []float64{1, 1, 1, 1, 1, 1, 1}
+
+	minimum, err := selectAlpha(scores, standardErrors, MinimumCVError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minimum != 2 {
+		t.Errorf("expected the minimum error at index 2, got %d", minimum)
+	}
+
+	oneSE, err := selectAlpha(scores, standardErrors, OneStandardError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oneSE != 4 {
+		t.Errorf("expected the largest alpha within one standard error at index 4, got %d", oneSE)
+	}
+
+	if _, err := selectAlpha(scores, standardErrors, AlphaSelection(-1)); err == nil {
+		t.Errorf("expected an error for an unknown alpha selection")
+	}
+	if _, err := selectAlpha([]float64{}, []float64{}, MinimumCVError); err == nil {
+		t.Errorf("expected an error for no scores")
+	}
+	if _, _, _, err := GetAlphaIndexFromCrossValidation([]float64{}, buildSplitDataset(false), RegressionEvaluator{}, BuildOptions{}); err == nil {
+		t.Errorf("expected an error for no alphas")
+	}
+}
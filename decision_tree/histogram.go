@@ -0,0 +1,224 @@
+package decision_tree
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// binning holds the upper edge of each bin of each continuous feature column. It's computed once
+// from the rows at the root, and shared by every node of the tree.
+type binning struct {
+	// edges[c] is sorted, and nil for categorical columns. A value v belongs to the first bin whose
+	// edge is >= v.
+	edges [][]float64
+}
+
+// newBinning splits each continuous feature column of ds into at most maxBins bins holding about
+// the same number of rows. Columns with no more than maxBins distinct values get a bin per value,
+// so that split search finds the same splits it would without binning.
+func newBinning(ds *dataset.Dataset, maxBins int) (*binning, error) {
+	if maxBins < 1 {
+		return nil, fmt.Errorf("cannot bin columns into %d bins", maxBins)
+	}
+
+	b := binning{edges: make([][]float64, len(ds.ColumnNames)-1)}
+	for c := range b.edges {
+		if !ds.ColumnIsContinuous[c] {
+			continue
+		}
+
+		// missing values aren't binned
+		values := []float64{}
+		for _, row := range ds.Rows {
+			if !math.IsNaN(row[c]) {
+				values = append(values, row[c])
+			}
+		}
+		sort.Float64s(values)
+
+		distinct := []float64{}
+		for i, v := range values {
+			if i == 0 || v != values[i-1] {
+				distinct = append(distinct, v)
+			}
+		}
+		if len(distinct) == 0 || len(distinct) <= maxBins {
+			b.edges[c] = distinct
+			continue
+		}
+
+		// the edge of bin i is the (i + 1) / maxBins quantile. bins whose quantiles land on the
+		// same value are merged
+		for i := 1; i <= maxBins; i++ {
+			edge := values[(i*len(values)-1)/maxBins]
+			if len(b.edges[c]) == 0 || edge != b.edges[c][len(b.edges[c])-1] {
+				b.edges[c] = append(b.edges[c], edge)
+			}
+		}
+	}
+	return &b, nil
+}
+
+// bin returns the bin of value in column c. Values above the last edge (which can only come from
+// rows that weren't at the root) go in the last bin.
+func (b *binning) bin(c int, value float64) int {
+	i := sort.SearchFloat64s(b.edges[c], value)
+	if i == len(b.edges[c]) {
+		return i - 1
+	}
+	return i
+}
+
+// histogram holds the statistics of a node's rows in each bin of each continuous column
+type histogram struct {
+	bins *binning
+	// stats[c][b] and sizes[c][b] are the statistics and size of the rows in bin b of column c
+	stats [][]SplitStats
+	sizes [][]sideSize
+	// missing[c] and missingSizes[c] are the statistics and size of the rows missing a value for
+	// column c
+	missing      []SplitStats
+	missingSizes []sideSize
+}
+
+// newHistogram returns an empty histogram for rows of ds
+func newHistogram(ds *dataset.Dataset, bins *binning, statsEvaluator StatsEvaluator) (*histogram, error) {
+	h := histogram{
+		bins:         bins,
+		stats:        make([][]SplitStats, len(bins.edges)),
+		sizes:        make([][]sideSize, len(bins.edges)),
+		missing:      make([]SplitStats, len(bins.edges)),
+		missingSizes: make([]sideSize, len(bins.edges)),
+	}
+	for c, edges := range bins.edges {
+		if edges == nil {
+			continue
+		}
+		missing, err := statsEvaluator.NewStats(ds)
+		if err != nil {
+			return nil, err
+		}
+		h.missing[c] = missing
+		h.stats[c] = make([]SplitStats, len(edges))
+		h.sizes[c] = make([]sideSize, len(edges))
+		for b := range edges {
+			stats, err := statsEvaluator.NewStats(ds)
+			if err != nil {
+				return nil, err
+			}
+			h.stats[c][b] = stats
+		}
+	}
+	return &h, nil
+}
+
+// histogramOf returns the histogram of every row of ds
+func histogramOf(ds *dataset.Dataset, bins *binning, statsEvaluator StatsEvaluator) (*histogram, error) {
+	h, err := newHistogram(ds, bins, statsEvaluator)
+	if err != nil {
+		return nil, err
+	}
+	for c, edges := range bins.edges {
+		if edges == nil {
+			continue
+		}
+		for r, row := range ds.Rows {
+			if math.IsNaN(row[c]) {
+				h.missing[c].Add(row.Y(), ds.Weight(r))
+				h.missingSizes[c].add(ds.Weight(r))
+				continue
+			}
+			b := bins.bin(c, row[c])
+			h.stats[c][b].Add(row.Y(), ds.Weight(r))
+			h.sizes[c][b].add(ds.Weight(r))
+		}
+	}
+	return h, nil
+}
+
+// split returns the histograms of a partition's true and false sides. Only the smaller side is
+// counted from its rows, and the larger side is this histogram less the smaller one.
+func (h *histogram) split(partition *dataset.Partition, statsEvaluator StatsEvaluator) (*histogram, *histogram, error) {
+	small, large := partition.True, partition.False
+	if small.Size() > large.Size() {
+		small, large = large, small
+	}
+
+	smallHistogram, err := histogramOf(small, h.bins, statsEvaluator)
+	if err != nil {
+		return nil, nil, err
+	}
+	largeHistogram, err := newHistogram(large, h.bins, statsEvaluator)
+	if err != nil {
+		return nil, nil, err
+	}
+	for c := range h.stats {
+		if h.missing[c] != nil {
+			largeHistogram.missing[c].AddStats(h.missing[c])
+			largeHistogram.missing[c].RemoveStats(smallHistogram.missing[c])
+			largeHistogram.missingSizes[c] = h.missingSizes[c].minus(smallHistogram.missingSizes[c])
+		}
+		for b := range h.stats[c] {
+			largeHistogram.stats[c][b].AddStats(h.stats[c][b])
+			largeHistogram.stats[c][b].RemoveStats(smallHistogram.stats[c][b])
+			largeHistogram.sizes[c][b] = h.sizes[c][b].minus(smallHistogram.sizes[c][b])
+		}
+	}
+
+	if small == partition.True {
+		return smallHistogram, largeHistogram, nil
+	}
+	return largeHistogram, smallHistogram, nil
+}
+
+// histogramSplitsOnColumn scores splitting a continuous column at each of its bin edges, sweeping
+// bins from the true side to the false side like sweepSplitsOnColumn sweeps rows. Rows missing a
+// value go to whichever side scores better.
+func histogramSplitsOnColumn(ds *dataset.Dataset, h *histogram, statsEvaluator StatsEvaluator, evaluator Evaluator, limits leafLimits, c int) (*splitCandidate, error) {
+	node, err := statsEvaluator.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	trueStats, err := statsEvaluator.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	falseStats, err := statsEvaluator.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	trueSize, falseSize := sideSize{}, sideSize{}
+	for b, stats := range h.stats[c] {
+		node.AddStats(stats)
+		trueStats.AddStats(stats)
+		trueSize = trueSize.plus(h.sizes[c][b])
+	}
+	node.AddStats(h.missing[c])
+
+	var best *splitCandidate
+	for b, stats := range h.stats[c] {
+		// empty bins would score the same split as the bin before them
+		if h.sizes[c][b].count == 0 {
+			continue
+		}
+		trueStats.RemoveStats(stats)
+		falseStats.AddStats(stats)
+		trueSize, falseSize = trueSize.minus(h.sizes[c][b]), falseSize.plus(h.sizes[c][b])
+
+		candidate, err := evaluateWithMissing(statsEvaluator, evaluator, limits, node, falseStats, trueStats, h.missing[c], falseSize, trueSize, h.missingSizes[c])
+		if err != nil {
+			return nil, err
+		}
+		if candidate == nil {
+			continue
+		}
+		candidate.column, candidate.value = c, h.bins.edges[c][b]
+		if best == nil || evaluator.IsBetter(candidate.score, best.score) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
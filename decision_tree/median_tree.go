@@ -0,0 +1,213 @@
+package decision_tree
+
+import "math"
+
+// medianTree holds weighted targets, so that their weighted median and the sum of absolute
+// residuals around it can be found in O(log n) as targets are added and removed. It's a treap of
+// distinct target values whose priorities are a hash of the value, so its shape (and the order
+// that its sums are added in) only depends on which values it holds, not the order they came in.
+type medianTree struct {
+	root *medianNode
+}
+
+// medianNode is a distinct target value, along with the number and total weight of the targets
+// with that value. subtreeWeight and subtreeSum are the total weight, and weighted sum, of the
+// targets in the subtree starting at the node.
+type medianNode struct {
+	value         float64
+	weight        float64
+	count         int
+	priority      uint64
+	left, right   *medianNode
+	subtreeWeight float64
+	subtreeSum    float64
+}
+
+// add adds count targets with value, whose weights sum to weight
+func (t *medianTree) add(value, weight float64, count int) {
+	t.root = t.root.add(value, weight, count)
+}
+
+// remove removes count targets with value, whose weights sum to weight
+func (t *medianTree) remove(value, weight float64, count int) {
+	t.root = t.root.remove(value, weight, count)
+}
+
+// addTree adds every target of other
+func (t *medianTree) addTree(other *medianTree) {
+	other.root.each(func(n *medianNode) { t.add(n.value, n.weight, n.count) })
+}
+
+// removeTree removes every target of other
+func (t *medianTree) removeTree(other *medianTree) {
+	other.root.each(func(n *medianNode) { t.remove(n.value, n.weight, n.count) })
+}
+
+// sumAbsoluteResiduals returns the weighted sum of absolute differences between each target and
+// their weighted median, which is found like weightedMedian
+func (t *medianTree) sumAbsoluteResiduals() float64 {
+	if t.root == nil {
+		return 0
+	}
+	total, sum := t.root.subtreeWeight, t.root.subtreeSum
+
+	// find the first value whose cumulative weight reaches half of the total, along with the
+	// weight and sum of the values up to it, and the next value after it
+	var median, next *medianNode
+	belowWeight, belowSum := 0.0, 0.0
+	for n := t.root; n != nil; {
+		if n.left != nil && belowWeight+n.left.subtreeWeight >= total/2 {
+			next = n
+			n = n.left
+			continue
+		}
+		belowWeight += n.left.weightOrZero() + n.weight
+		belowSum += n.left.sumOrZero() + n.weight*n.value
+		if belowWeight >= total/2 {
+			median = n
+			if n.right != nil {
+				next = n.right.min()
+			}
+			break
+		}
+		n = n.right
+	}
+	if median == nil {
+		return 0
+	}
+
+	m := median.value
+	if belowWeight == total/2 && next != nil {
+		m = (median.value + next.value) / 2
+	}
+	return math.Max(0, m*belowWeight-belowSum+(sum-belowSum)-m*(total-belowWeight))
+}
+
+func (n *medianNode) add(value, weight float64, count int) *medianNode {
+	if n == nil {
+		n = &medianNode{value: value, weight: weight, count: count, priority: valuePriority(value)}
+		n.update()
+		return n
+	}
+
+	switch {
+	case value < n.value:
+		n.left = n.left.add(value, weight, count)
+		if n.left.priority > n.priority {
+			return n.rotateRight()
+		}
+	case value > n.value:
+		n.right = n.right.add(value, weight, count)
+		if n.right.priority > n.priority {
+			return n.rotateLeft()
+		}
+	default:
+		n.weight += weight
+		n.count += count
+	}
+	n.update()
+	return n
+}
+
+func (n *medianNode) remove(value, weight float64, count int) *medianNode {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case value < n.value:
+		n.left = n.left.remove(value, weight, count)
+	case value > n.value:
+		n.right = n.right.remove(value, weight, count)
+	default:
+		n.weight -= weight
+		n.count -= count
+		if n.count <= 0 {
+			return merge(n.left, n.right)
+		}
+	}
+	n.update()
+	return n
+}
+
+// merge joins two treaps, where every value in a is smaller than every value in b
+func merge(a, b *medianNode) *medianNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = merge(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = merge(a, b.left)
+	b.update()
+	return b
+}
+
+func (n *medianNode) rotateRight() *medianNode {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func (n *medianNode) rotateLeft() *medianNode {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+// update recomputes the subtree totals of n from its children
+func (n *medianNode) update() {
+	n.subtreeWeight = n.left.weightOrZero() + n.weight + n.right.weightOrZero()
+	n.subtreeSum = n.left.sumOrZero() + n.weight*n.value + n.right.sumOrZero()
+}
+
+func (n *medianNode) weightOrZero() float64 {
+	if n == nil {
+		return 0
+	}
+	return n.subtreeWeight
+}
+
+func (n *medianNode) sumOrZero() float64 {
+	if n == nil {
+		return 0
+	}
+	return n.subtreeSum
+}
+
+// min returns the node with the smallest value in the subtree starting at n
+func (n *medianNode) min() *medianNode {
+	for n.left != nil {
+		n = n.left
+	}
+	return n
+}
+
+// each calls f on every node of the subtree starting at n, in order of value
+func (n *medianNode) each(f func(*medianNode)) {
+	if n == nil {
+		return
+	}
+	n.left.each(f)
+	f(n)
+	n.right.each(f)
+}
+
+// valuePriority hashes value (with splitmix64) into the priority of its treap node
+func valuePriority(value float64) uint64 {
+	x := math.Float64bits(value) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,50 @@
+package decision_tree
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestMedianTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tree := medianTree{}
+	targets := []weightedTarget{}
+
+	for step := 0; step < 2000; step++ {
+		if len(targets) > 0 && rng.Float64() < .4 {
+			i := rng.Intn(len(targets))
+			tree.remove(targets[i].value, targets[i].weight, 1)
+			targets = append(targets[:i], targets[i+1:]...)
+		} else {
+			// few distinct values, so that many targets share one
+			target := weightedTarget{value: float64(rng.Intn(20)) - 5, weight: float64(1 + rng.Intn(3))}
+			tree.add(target.value, target.weight, 1)
+			targets = append(targets, target)
+		}
+
+		sorted := append([]weightedTarget{}, targets...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].value < sorted[j].value })
+		median := weightedMedian(sorted)
+		expected := 0.0
+		for _, target := range sorted {
+			expected += target.weight * math.Abs(target.value-median)
+		}
+
+		if got := tree.sumAbsoluteResiduals(); math.Abs(got-expected) > 1e-9 {
+			t.Fatalf("step %d: expected absolute residuals of %v around %v, got %v", step, expected, median, got)
+		}
+	}
+
+	// removing a tree that was added leaves the original
+	other := medianTree{}
+	other.add(100, 2, 1)
+	other.add(-100, 1, 1)
+	before := tree.sumAbsoluteResiduals()
+	tree.addTree(&other)
+	tree.removeTree(&other)
+	if got := tree.sumAbsoluteResiduals(); math.Abs(got-before) > 1e-9 {
+		t.Errorf("expected adding and removing a tree to leave %v, got %v", before, got)
+	}
+}
@@ -0,0 +1,117 @@
+package decision_tree
+
+import (
+	"fmt"
+	"math"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// ReducedErrorPrune returns a copy of the tree starting at n, pruned against held-out validation
+// data. Working bottom-up, each internal node becomes a leaf when that doesn't increase the
+// (weighted) error of the validation rows that reach it. Nodes that no validation rows reach are
+// pruned. n isn't modified.
+func (n *DecisionNode) ReducedErrorPrune(validation *dataset.Dataset) (*DecisionNode, error) {
+	if len(validation.ColumnNames) != len(n.TrainData.ColumnNames) {
+		return nil, fmt.Errorf("could not prune, expected %d columns, had %d", len(n.TrainData.ColumnNames), len(validation.ColumnNames))
+	}
+
+	rows := make([]int, validation.Size())
+	for r := range rows {
+		rows[r] = r
+	}
+
+	pruned := n.DeepClone()
+	if _, err := pruned.reducedErrorPrune(validation, rows); err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
+
+// reducedErrorPrune prunes the subtree at n with the rows of validation at the provided indices,
+// and returns their error on the pruned subtree
+func (n *DecisionNode) reducedErrorPrune(validation *dataset.Dataset, rows []int) (float64, error) {
+	asLeafErr := 0.0
+	prediction := n.prediction()
+	for _, r := range rows {
+		asLeafErr += validation.Weight(r) * n.Evaluator.GetSingleError(validation.Rows[r].Y(), prediction)
+	}
+	if n.L == nil || n.R == nil {
+		return asLeafErr, nil
+	}
+
+	lRows, rRows := []int{}, []int{}
+	for _, r := range rows {
+		if n.evaluateRow(validation.Rows[r]) {
+			rRows = append(rRows, r)
+		} else {
+			lRows = append(lRows, r)
+		}
+	}
+	lErr, err := n.L.reducedErrorPrune(validation, lRows)
+	if err != nil {
+		return 0, err
+	}
+	rErr, err := n.R.reducedErrorPrune(validation, rRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if asLeafErr <= lErr+rErr {
+		n.L, n.R = nil, nil
+		return asLeafErr, nil
+	}
+	return lErr + rErr, nil
+}
+
+// PessimisticPrune returns a copy of the classification tree starting at n, pruned like C4.5
+// without a held-out dataset. A node's errors are estimated pessimistically as its number of
+// training rows times the upper confidence limit of its training error rate, and working
+// bottom-up, each internal node becomes a leaf when its estimate is no more than the sum of its
+// leaves' estimates. Smaller confidence levels prune more, and C4.5 uses .25 by default. n isn't
+// modified.
+func (n *DecisionNode) PessimisticPrune(confidence float64) (*DecisionNode, error) {
+	if _, ok := n.Evaluator.(ClassificationEvaluator); !ok {
+		return nil, fmt.Errorf("pessimistic pruning requires a classification tree")
+	}
+	if confidence <= 0 || confidence >= 1 {
+		return nil, fmt.Errorf("confidence must be between 0 and 1, was %v", confidence)
+	}
+
+	// the one-sided z score of the confidence level
+	z := math.Sqrt2 * math.Erfinv(1-2*confidence)
+
+	pruned := n.DeepClone()
+	pruned.pessimisticPrune(z)
+	return pruned, nil
+}
+
+// pessimisticPrune prunes the subtree at n, and returns its estimated errors
+func (n *DecisionNode) pessimisticPrune(z float64) float64 {
+	asLeafErr := n.pessimisticError(z)
+	if n.L == nil || n.R == nil {
+		return asLeafErr
+	}
+
+	subtreeErr := n.L.pessimisticPrune(z) + n.R.pessimisticPrune(z)
+	if asLeafErr <= subtreeErr {
+		n.L, n.R = nil, nil
+		return asLeafErr
+	}
+	return subtreeErr
+}
+
+// pessimisticError returns the weight of n's training rows times the upper limit of the (Wilson)
+// confidence interval of the rate at which n misclassifies them as a leaf
+func (n *DecisionNode) pessimisticError(z float64) float64 {
+	total, misclassified := 0.0, 0.0
+	prediction := n.prediction()
+	for r, row := range n.TrainData.Rows {
+		total += n.TrainData.Weight(r)
+		misclassified += n.TrainData.Weight(r) * n.Evaluator.GetSingleError(row.Y(), prediction)
+	}
+
+	f, z2 := misclassified/total, z*z
+	upper := (f + z2/(2*total) + z*math.Sqrt(f/total-f*f/total+z2/(4*total*total))) / (1 + z2/total)
+	return total * upper
+}
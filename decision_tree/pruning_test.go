@@ -0,0 +1,112 @@
+package decision_tree
+
+import (
+	"testing"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// buildPruningDataset returns rows whose class is x >= 10, except that x = 3 is mislabeled when
+// noisy is set
+func buildPruningDataset(noisy bool) *dataset.Dataset {
+	ds := dataset.NewDataset(
+		[]string{"x", "class"},
+		[]bool{true, false},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for x := 0.0; x < 20; x++ {
+		class := 0.0
+		if x >= 10 || (noisy && x == 3) {
+			class = 1
+		}
+		ds.InsertRow(dataset.Row{x, class})
+	}
+	return ds
+}
+
+func countLeaves(t *testing.T, tree *DecisionNode) int {
+	leaves, err := tree.getLeaves()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(*leaves)
+}
+
+func TestReducedErrorPrune(t *testing.T) {
+	tree, err := BuildTreeWithOverfitting(buildPruningDataset(true), ClassificationEvaluator{}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := countLeaves(t, tree)
+	if leaves <= 2 {
+		t.Fatalf("expected the tree to fit the mislabeled row, got %d leaves", leaves)
+	}
+
+	pruned, err := tree.ReducedErrorPrune(buildPruningDataset(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countLeaves(t, pruned) != 2 || pruned.Partition.Value != 9 {
+		t.Errorf("expected pruning to leave the split on x > 9, got %d leaves", countLeaves(t, pruned))
+	}
+	if countLeaves(t, tree) != leaves {
+		t.Errorf("expected the original tree to keep %d leaves, got %d", leaves, countLeaves(t, tree))
+	}
+
+	// pruning against the training data can't remove anything that lowers its error
+	unpruned, err := tree.ReducedErrorPrune(buildPruningDataset(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range buildPruningDataset(true).Rows {
+		pred, err := unpruned.Predict(row.X())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *pred != row.Y() {
+			t.Errorf("expected x = %v to still predict %v, got %v", row[0], row.Y(), *pred)
+		}
+	}
+
+	if _, err := tree.ReducedErrorPrune(dataset.NewDataset([]string{"y"}, []bool{true}, []dataset.Row{}, &dataset.EnumMapper{})); err == nil {
+		t.Errorf("expected an error for validation data with different columns")
+	}
+}
+
+func TestPessimisticPrune(t *testing.T) {
+	tree, err := BuildTreeWithOverfitting(buildPruningDataset(true), ClassificationEvaluator{}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := countLeaves(t, tree)
+
+	cautious, err := tree.PessimisticPrune(.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggressive, err := tree.PessimisticPrune(.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countLeaves(t, aggressive) != 2 || aggressive.Partition.Value != 9 {
+		t.Errorf("expected a low confidence to leave the split on x > 9, got %d leaves", countLeaves(t, aggressive))
+	}
+	if countLeaves(t, cautious) <= countLeaves(t, aggressive) {
+		t.Errorf("expected a higher confidence to prune less, got %d leaves", countLeaves(t, cautious))
+	}
+	if countLeaves(t, tree) != leaves {
+		t.Errorf("expected the original tree to keep %d leaves, got %d", leaves, countLeaves(t, tree))
+	}
+
+	if _, err := tree.PessimisticPrune(1); err == nil {
+		t.Errorf("expected an error for a confidence of 1")
+	}
+	regression, err := BuildTreeWithOverfitting(buildSplitDataset(false), RegressionEvaluator{}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := regression.PessimisticPrune(.25); err == nil {
+		t.Errorf("expected an error for a regression tree")
+	}
+}
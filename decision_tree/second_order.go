@@ -0,0 +1,170 @@
+package decision_tree
+
+import (
+	"fmt"
+	"math"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// minHessian keeps rows with a (near) zero hessian from producing infinite targets
+const minHessian = 1e-12
+
+// SecondOrderEvaluator builds XGBoost-style regularized trees from the gradient (g) and hessian (h)
+// of a loss. A node's rows have summed gradient G and summed hessian H, its leaf weight is
+// -T(G) / (H + Lambda), and a split's gain is
+//
+//	.5 * (T(G_L)^2 / (H_L + Lambda) + T(G_R)^2 / (H_R + Lambda) - T(G)^2 / (H + Lambda)) - Gamma
+//
+// where T(G) shrinks G towards 0 by Alpha (L1 penalty). Lambda is the L2 penalty on leaf weights,
+// and splits that don't gain at least Gamma are never made.
+//
+// Use BuildSecondOrderTree to build trees with this evaluator. It encodes each row with the target
+// -g/h and weight h, so that sums of weight are sums of hessians, and sums of weight * target are
+// negative sums of gradients.
+type SecondOrderEvaluator struct {
+	Lambda float64
+	Alpha  float64
+	Gamma  float64
+}
+
+// BuildSecondOrderTree builds a tree on the features of ds, fit to the gradient and hessian of each
+// row's loss. Row weights in ds scale both. The weight of each leaf is kept in its Value.
+func BuildSecondOrderTree(ds *dataset.Dataset, gradients, hessians []float64, evaluator SecondOrderEvaluator, options BuildOptions) (*DecisionNode, error) {
+	if len(gradients) != ds.Size() || len(hessians) != ds.Size() {
+		return nil, fmt.Errorf("expected %d gradients and hessians, had %d and %d", ds.Size(), len(gradients), len(hessians))
+	}
+
+	continuous := make([]bool, len(ds.ColumnIsContinuous))
+	copy(continuous, ds.ColumnIsContinuous)
+	continuous[len(continuous)-1] = true
+
+	encoded := dataset.NewDataset(ds.ColumnNames, continuous, []dataset.Row{}, ds.EnumMapper)
+	for r, row := range ds.Rows {
+		h := math.Max(hessians[r], minHessian)
+		newRow := make(dataset.Row, len(row))
+		copy(newRow, row)
+		newRow[len(newRow)-1] = -gradients[r] / h
+		encoded.InsertWeightedRow(newRow, ds.Weight(r)*h)
+	}
+
+	tree, err := BuildTreeWithOverfitting(encoded, evaluator, options)
+	if err != nil {
+		return nil, err
+	}
+	tree.CacheLeafValues()
+	return tree, nil
+}
+
+// EvaluateSplit returns the regularized gain of the split, less Gamma
+func (s SecondOrderEvaluator) EvaluateSplit(dataset *dataset.Dataset, partition *dataset.Partition) (*float64, error) {
+	G, H := gradientSums(dataset)
+	GL, HL := gradientSums(partition.False)
+	GR, HR := gradientSums(partition.True)
+
+	gain := 0.5*(s.structureScore(GL, HL)+s.structureScore(GR, HR)-s.structureScore(G, H)) - s.Gamma
+	return &gain, nil
+}
+
+// NewStats returns the summed gradient and hessian of rows encoded by BuildSecondOrderTree
+func (s SecondOrderEvaluator) NewStats(ds *dataset.Dataset) (SplitStats, error) {
+	return &gradientStats{}, nil
+}
+
+// EvaluateStats returns the regularized gain of the split, less Gamma, like EvaluateSplit
+func (s SecondOrderEvaluator) EvaluateStats(node, falseStats, trueStats SplitStats) (*float64, error) {
+	S, L, R := node.(*gradientStats), falseStats.(*gradientStats), trueStats.(*gradientStats)
+
+	gain := 0.5*(s.structureScore(L.G, L.H)+s.structureScore(R.G, R.H)-s.structureScore(S.G, S.H)) - s.Gamma
+	return &gain, nil
+}
+
+// gradientStats are the SplitStats of a SecondOrderEvaluator
+type gradientStats struct {
+	G float64
+	H float64
+}
+
+func (g *gradientStats) Add(target, weight float64) {
+	g.G -= weight * target
+	g.H += weight
+}
+
+func (g *gradientStats) Remove(target, weight float64) {
+	g.G += weight * target
+	g.H -= weight
+}
+
+func (g *gradientStats) AddStats(other SplitStats) {
+	o := other.(*gradientStats)
+	g.G += o.G
+	g.H += o.H
+}
+
+func (g *gradientStats) RemoveStats(other SplitStats) {
+	o := other.(*gradientStats)
+	g.G -= o.G
+	g.H -= o.H
+}
+
+// GetErrorAtNode is the weighted sum of squared residuals around the node's leaf weight, which
+// is (twice) the node's loss under a second order approximation, plus a constant
+func (s SecondOrderEvaluator) GetErrorAtNode(node *DecisionNode) (*float64, error) {
+	leafWeight := s.Predict(node)
+
+	totalError := 0.0
+	for i, row := range node.TrainData.Rows {
+		totalError += node.TrainData.Weight(i) * s.GetSingleError(row.Y(), leafWeight)
+	}
+	return &totalError, nil
+}
+
+// GetSingleError returns the squared residual
+func (s SecondOrderEvaluator) GetSingleError(actual, predicted float64) float64 {
+	return (actual - predicted) * (actual - predicted)
+}
+
+// Predict returns the regularized leaf weight, -T(G) / (H + Lambda)
+func (s SecondOrderEvaluator) Predict(node *DecisionNode) float64 {
+	G, H := gradientSums(node.TrainData)
+	return -s.shrink(G) / (H + s.Lambda)
+}
+
+func (s SecondOrderEvaluator) IsBetter(newScore, oldScore float64) bool {
+	return newScore > oldScore
+}
+
+// AcceptSplit only accepts splits with a positive gain, since Gamma has already been subtracted
+func (s SecondOrderEvaluator) AcceptSplit(score float64) bool {
+	return score > 0
+}
+
+// structureScore is T(G)^2 / (H + Lambda). The larger it is, the more a leaf lowers the loss.
+func (s SecondOrderEvaluator) structureScore(G, H float64) float64 {
+	if H+s.Lambda <= 0 {
+		return 0
+	}
+	shrunk := s.shrink(G)
+	return shrunk * shrunk / (H + s.Lambda)
+}
+
+// shrink moves G towards 0 by Alpha, stopping at 0
+func (s SecondOrderEvaluator) shrink(G float64) float64 {
+	if G > s.Alpha {
+		return G - s.Alpha
+	}
+	if G < -s.Alpha {
+		return G + s.Alpha
+	}
+	return 0
+}
+
+// gradientSums returns the summed gradient and hessian of rows encoded by BuildSecondOrderTree
+func gradientSums(ds *dataset.Dataset) (float64, float64) {
+	G, H := 0.0, 0.0
+	for i, row := range ds.Rows {
+		G -= ds.Weight(i) * row.Y()
+		H += ds.Weight(i)
+	}
+	return G, H
+}
@@ -0,0 +1,494 @@
+package decision_tree
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// SplitStats are running sufficient statistics of the targets of a set of rows, e.g. their count
+// and sum, that rows can be added to and removed from one at a time. AddStats and RemoveStats add
+// or remove every row counted by other, which must come from the same evaluator.
+type SplitStats interface {
+	Add(target, weight float64)
+	Remove(target, weight float64)
+	AddStats(other SplitStats)
+	RemoveStats(other SplitStats)
+}
+
+// StatsEvaluator is implemented by evaluators that can score a split from the statistics of each
+// side. Split search then sorts each continuous column once and sweeps its rows from the true side
+// to the false side, instead of partitioning the dataset at every candidate value. Evaluators that
+// don't implement it are scored with EvaluateSplit.
+type StatsEvaluator interface {
+	// NewStats returns the statistics of an empty set of rows from ds
+	NewStats(ds *dataset.Dataset) (SplitStats, error)
+	// EvaluateStats returns the same score as EvaluateSplit, given the statistics of the node's rows
+	// and of the rows in its false and true partitions
+	EvaluateStats(node, falseStats, trueStats SplitStats) (*float64, error)
+}
+
+// splitCandidate is the best value to partition a node's rows on for one column, and the side
+// that rows missing a value for it go to
+type splitCandidate struct {
+	column int
+	value  float64
+	// categories is only set for subset splits of categorical columns, and holds the categories
+	// that go to the true side
+	categories      []float64
+	missingGoesTrue bool
+	score           float64
+}
+
+// bestSplit returns the best partition of ds on any of columns, and its score. Ties go to the
+// earlier column, and then to the smaller value. The partition is nil if no column has a value to
+// partition on. Continuous columns are split on bin edges when h isn't nil. Up to options.Workers
+// columns are searched at once.
+func bestSplit(ds *dataset.Dataset, evaluator Evaluator, columns []int, h *histogram, options BuildOptions) (*dataset.Partition, *float64, error) {
+	candidates := make([]*splitCandidate, len(columns))
+	errs := make([]error, len(columns))
+	search := func(i int) {
+		candidates[i], errs[i] = bestSplitOnColumn(ds, evaluator, columns[i], h, options)
+	}
+
+	workers := options.workers()
+	if workers <= 1 || len(columns) <= 1 {
+		for i := range columns {
+			search(i)
+		}
+	} else {
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers && w < len(columns); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					search(i)
+				}
+			}()
+		}
+		for i := range columns {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
+
+	// compare columns in order, so that the result doesn't depend on which finished first
+	var best *splitCandidate
+	for i, candidate := range candidates {
+		if errs[i] != nil {
+			return nil, nil, errs[i]
+		}
+		if candidate != nil && (best == nil || evaluator.IsBetter(candidate.score, best.score)) {
+			best = candidate
+		}
+	}
+	if best == nil {
+		return nil, nil, nil
+	}
+
+	// only the winning split is ever partitioned
+	var partition *dataset.Partition
+	var err error
+	if best.categories != nil {
+		partition, err = ds.PartitionByCategories(ds.ColumnNames[best.column], best.categories, best.missingGoesTrue)
+	} else {
+		partition, err = ds.PartitionWithMissing(ds.ColumnNames[best.column], best.value, best.missingGoesTrue)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return partition, &best.score, nil
+}
+
+// bestSplitOnColumn returns the best value to partition ds on in column c, or nil if ds has no rows
+// or no split leaves both sides large enough. Categorical columns are split into subsets of
+// categories when options.CategorySubsets is set and the evaluator supports it.
+func bestSplitOnColumn(ds *dataset.Dataset, evaluator Evaluator, c int, h *histogram, options BuildOptions) (*splitCandidate, error) {
+	limits := options.leafLimits()
+	statsEvaluator, ok := evaluator.(StatsEvaluator)
+	if !ok {
+		return partitionSplitsOnColumn(ds, evaluator, limits, c)
+	}
+	if ds.ColumnIsContinuous[c] && h != nil {
+		return histogramSplitsOnColumn(ds, h, statsEvaluator, evaluator, limits, c)
+	}
+	if ds.ColumnIsContinuous[c] {
+		return sweepSplitsOnColumn(ds, statsEvaluator, evaluator, limits, c)
+	}
+	if options.CategorySubsets && orderableByMean(ds, evaluator) {
+		return subsetSplitsOnColumn(ds, statsEvaluator, evaluator, limits, c)
+	}
+	return categorySplitsOnColumn(ds, statsEvaluator, evaluator, limits, c)
+}
+
+// sideSize is the number of rows on one side of a split, and their total weight
+type sideSize struct {
+	count  int
+	weight float64
+}
+
+// add adds a row with weight to the side
+func (s *sideSize) add(weight float64) {
+	s.count++
+	s.weight += weight
+}
+
+// remove removes a row with weight from the side
+func (s *sideSize) remove(weight float64) {
+	s.count--
+	s.weight -= weight
+}
+
+// plus returns the size of both sides together
+func (s sideSize) plus(other sideSize) sideSize {
+	return sideSize{count: s.count + other.count, weight: s.weight + other.weight}
+}
+
+// minus returns the size of s without the rows of other
+func (s sideSize) minus(other sideSize) sideSize {
+	return sideSize{count: s.count - other.count, weight: s.weight - other.weight}
+}
+
+// sizeOf returns the number of rows in ds and their total weight
+func sizeOf(ds *dataset.Dataset) sideSize {
+	return sideSize{count: ds.Size(), weight: ds.TotalWeight()}
+}
+
+// sizeOfRows returns the number of rows at the provided indices of ds, and their total weight
+func sizeOfRows(ds *dataset.Dataset, rows []int) sideSize {
+	size := sideSize{}
+	for _, r := range rows {
+		size.add(ds.Weight(r))
+	}
+	return size
+}
+
+// leafLimits are the fewest rows, and the least total weight, that each side of a split can have
+type leafLimits struct {
+	minSamples int
+	minWeight  float64
+}
+
+// allows returns true if both sides of a split are large enough
+func (l leafLimits) allows(falseSize, trueSize sideSize) bool {
+	return falseSize.count >= l.minSamples && trueSize.count >= l.minSamples &&
+		falseSize.weight >= l.minWeight && trueSize.weight >= l.minWeight
+}
+
+// sweepSplitsOnColumn scores every threshold of a continuous column in a single pass over its
+// sorted rows. Every row starts on the true side (value > threshold), and rows move to the false
+// side as the threshold reaches their value. Rows missing a value sit out the sweep, and go to
+// whichever side scores better at each threshold.
+func sweepSplitsOnColumn(ds *dataset.Dataset, statsEvaluator StatsEvaluator, evaluator Evaluator, limits leafLimits, c int) (*splitCandidate, error) {
+	order, missingRows := presentAndMissing(ds, c)
+	sort.SliceStable(order, func(i, j int) bool {
+		return ds.Rows[order[i]][c] < ds.Rows[order[j]][c]
+	})
+
+	node, err := statsOf(ds, statsEvaluator, append(append([]int{}, order...), missingRows...))
+	if err != nil {
+		return nil, err
+	}
+	trueStats, err := statsOf(ds, statsEvaluator, order)
+	if err != nil {
+		return nil, err
+	}
+	falseStats, err := statsEvaluator.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	missing, err := statsOf(ds, statsEvaluator, missingRows)
+	if err != nil {
+		return nil, err
+	}
+
+	trueSize, falseSize, missingSize := sizeOfRows(ds, order), sideSize{}, sizeOfRows(ds, missingRows)
+
+	var best *splitCandidate
+	for i := 0; i < len(order); {
+		value := ds.Rows[order[i]][c]
+		for ; i < len(order) && ds.Rows[order[i]][c] == value; i++ {
+			r := order[i]
+			trueStats.Remove(ds.Rows[r].Y(), ds.Weight(r))
+			falseStats.Add(ds.Rows[r].Y(), ds.Weight(r))
+			trueSize.remove(ds.Weight(r))
+			falseSize.add(ds.Weight(r))
+		}
+
+		candidate, err := evaluateWithMissing(statsEvaluator, evaluator, limits, node, falseStats, trueStats, missing, falseSize, trueSize, missingSize)
+		if err != nil {
+			return nil, err
+		}
+		if candidate == nil {
+			continue
+		}
+		candidate.column, candidate.value = c, value
+		if best == nil || evaluator.IsBetter(candidate.score, best.score) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
+
+// categorySplitsOnColumn scores splitting each category of a categorical column from the rest
+func categorySplitsOnColumn(ds *dataset.Dataset, statsEvaluator StatsEvaluator, evaluator Evaluator, limits leafLimits, c int) (*splitCandidate, error) {
+	present, missingRows := presentAndMissing(ds, c)
+	rowsByValue := map[float64][]int{}
+	for _, r := range present {
+		rowsByValue[ds.Rows[r][c]] = append(rowsByValue[ds.Rows[r][c]], r)
+	}
+	values := make([]float64, 0, len(rowsByValue))
+	for value := range rowsByValue {
+		values = append(values, value)
+	}
+	sort.Float64s(values)
+
+	node, err := statsOf(ds, statsEvaluator, append(append([]int{}, present...), missingRows...))
+	if err != nil {
+		return nil, err
+	}
+	missing, err := statsOf(ds, statsEvaluator, missingRows)
+	if err != nil {
+		return nil, err
+	}
+	presentSize, missingSize := sizeOfRows(ds, present), sizeOfRows(ds, missingRows)
+
+	// the false side is every present row but the category's, so it's the present rows' stats
+	// with the category's taken out while it's scored
+	falseStats, err := statsOf(ds, statsEvaluator, present)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *splitCandidate
+	for _, value := range values {
+		trueStats, err := statsOf(ds, statsEvaluator, rowsByValue[value])
+		if err != nil {
+			return nil, err
+		}
+		trueSize := sizeOfRows(ds, rowsByValue[value])
+		falseSize := presentSize.minus(trueSize)
+
+		falseStats.RemoveStats(trueStats)
+		candidate, err := evaluateWithMissing(statsEvaluator, evaluator, limits, node, falseStats, trueStats, missing, falseSize, trueSize, missingSize)
+		falseStats.AddStats(trueStats)
+		if err != nil {
+			return nil, err
+		}
+		if candidate == nil {
+			continue
+		}
+		candidate.column, candidate.value = c, value
+		if best == nil || evaluator.IsBetter(candidate.score, best.score) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
+
+// orderableByMean returns true if sorting categories by their mean target and only trying splits
+// along that order is guaranteed to find the best subset split. This holds for squared error,
+// Friedman MSE, Poisson deviance and second order trees, and for Gini or entropy with at most two
+// classes (at this node). It doesn't for absolute error or gain ratio.
+func orderableByMean(ds *dataset.Dataset, evaluator Evaluator) bool {
+	switch e := evaluator.(type) {
+	case RegressionEvaluator:
+		return e.Criterion == SquaredError || e.Criterion == FriedmanMSE || e.Criterion == PoissonDeviance
+	case SecondOrderEvaluator:
+		return true
+	case ClassificationEvaluator:
+		if e.Criterion != Gini && e.Criterion != Entropy {
+			return false
+		}
+		classes := map[float64]bool{}
+		for _, row := range ds.Rows {
+			classes[row.Y()] = true
+		}
+		return len(classes) <= 2
+	default:
+		return false
+	}
+}
+
+// subsetSplitsOnColumn sorts the categories of a categorical column by their (weighted) mean
+// target, and then sweeps them from the true side to the false side like sweepSplitsOnColumn. Each
+// step splits the categories into the ones swept so far, and the rest, which go to the true side.
+func subsetSplitsOnColumn(ds *dataset.Dataset, statsEvaluator StatsEvaluator, evaluator Evaluator, limits leafLimits, c int) (*splitCandidate, error) {
+	present, missingRows := presentAndMissing(ds, c)
+	rowsByValue := map[float64][]int{}
+	sums := map[float64]float64{}
+	weights := map[float64]float64{}
+	for _, r := range present {
+		value := ds.Rows[r][c]
+		rowsByValue[value] = append(rowsByValue[value], r)
+		sums[value] += ds.Weight(r) * ds.Rows[r].Y()
+		weights[value] += ds.Weight(r)
+	}
+	order := make([]float64, 0, len(rowsByValue))
+	for value := range rowsByValue {
+		order = append(order, value)
+	}
+	sort.Float64s(order)
+	sort.SliceStable(order, func(i, j int) bool {
+		return sums[order[i]]/weights[order[i]] < sums[order[j]]/weights[order[j]]
+	})
+
+	node, err := statsOf(ds, statsEvaluator, append(append([]int{}, present...), missingRows...))
+	if err != nil {
+		return nil, err
+	}
+	trueStats, err := statsOf(ds, statsEvaluator, present)
+	if err != nil {
+		return nil, err
+	}
+	falseStats, err := statsEvaluator.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	missing, err := statsOf(ds, statsEvaluator, missingRows)
+	if err != nil {
+		return nil, err
+	}
+
+	trueSize, falseSize, missingSize := sizeOfRows(ds, present), sideSize{}, sizeOfRows(ds, missingRows)
+
+	var best *splitCandidate
+	for i, value := range order {
+		for _, r := range rowsByValue[value] {
+			trueStats.Remove(ds.Rows[r].Y(), ds.Weight(r))
+			falseStats.Add(ds.Rows[r].Y(), ds.Weight(r))
+			trueSize.remove(ds.Weight(r))
+			falseSize.add(ds.Weight(r))
+		}
+
+		candidate, err := evaluateWithMissing(statsEvaluator, evaluator, limits, node, falseStats, trueStats, missing, falseSize, trueSize, missingSize)
+		if err != nil {
+			return nil, err
+		}
+		if candidate == nil {
+			continue
+		}
+		candidate.column = c
+		candidate.categories = append([]float64{}, order[i+1:]...)
+		sort.Float64s(candidate.categories)
+		if best == nil || evaluator.IsBetter(candidate.score, best.score) {
+			best = candidate
+		}
+	}
+	return best, nil
+}
+
+// partitionSplitsOnColumn scores each distinct value of column c by partitioning ds on it, for
+// evaluators that can only score partitioned datasets. Rows missing a value are tried on both
+// sides.
+func partitionSplitsOnColumn(ds *dataset.Dataset, evaluator Evaluator, limits leafLimits, c int) (*splitCandidate, error) {
+	present, missingRows := presentAndMissing(ds, c)
+	values := []float64{}
+	seen := map[float64]bool{}
+	for _, r := range present {
+		if !seen[ds.Rows[r][c]] {
+			seen[ds.Rows[r][c]] = true
+			values = append(values, ds.Rows[r][c])
+		}
+	}
+	sort.Float64s(values)
+
+	directions := []bool{false}
+	if len(missingRows) > 0 {
+		directions = append(directions, true)
+	}
+
+	var best *splitCandidate
+	for _, value := range values {
+		for _, missingGoesTrue := range directions {
+			partition, err := ds.PartitionWithMissing(ds.ColumnNames[c], value, missingGoesTrue)
+			if err != nil {
+				return nil, err
+			}
+			if !limits.allows(sizeOf(partition.False), sizeOf(partition.True)) {
+				continue
+			}
+
+			score, err := evaluator.EvaluateSplit(ds, partition)
+			if err != nil {
+				return nil, err
+			}
+			if best == nil || evaluator.IsBetter(*score, best.score) {
+				best = &splitCandidate{column: c, value: value, missingGoesTrue: missingGoesTrue, score: *score}
+			}
+		}
+	}
+	return best, nil
+}
+
+// evaluateWithMissing scores a split with the rows missing a value on the false side, and then on
+// the true side, and returns the better of the two. The column and value of the candidate are left
+// for the caller. Missing rows go to the false side when there are none, or on a tie. Sides that
+// limits don't allow aren't scored, and the candidate is nil if neither is allowed.
+func evaluateWithMissing(statsEvaluator StatsEvaluator, evaluator Evaluator, limits leafLimits, node, falseStats, trueStats, missing SplitStats, falseSize, trueSize, missingSize sideSize) (*splitCandidate, error) {
+	if missingSize.count == 0 {
+		if !limits.allows(falseSize, trueSize) {
+			return nil, nil
+		}
+		score, err := statsEvaluator.EvaluateStats(node, falseStats, trueStats)
+		if err != nil {
+			return nil, err
+		}
+		return &splitCandidate{score: *score}, nil
+	}
+
+	var best *splitCandidate
+	if limits.allows(falseSize.plus(missingSize), trueSize) {
+		falseStats.AddStats(missing)
+		falseScore, err := statsEvaluator.EvaluateStats(node, falseStats, trueStats)
+		falseStats.RemoveStats(missing)
+		if err != nil {
+			return nil, err
+		}
+		best = &splitCandidate{score: *falseScore}
+	}
+
+	if limits.allows(falseSize, trueSize.plus(missingSize)) {
+		trueStats.AddStats(missing)
+		trueScore, err := statsEvaluator.EvaluateStats(node, falseStats, trueStats)
+		trueStats.RemoveStats(missing)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || evaluator.IsBetter(*trueScore, best.score) {
+			best = &splitCandidate{missingGoesTrue: true, score: *trueScore}
+		}
+	}
+	return best, nil
+}
+
+// presentAndMissing returns the indices of the rows of ds that have a value for column c, and of
+// those that are missing one (NaN)
+func presentAndMissing(ds *dataset.Dataset, c int) ([]int, []int) {
+	present, missing := []int{}, []int{}
+	for r, row := range ds.Rows {
+		if math.IsNaN(row[c]) {
+			missing = append(missing, r)
+		} else {
+			present = append(present, r)
+		}
+	}
+	return present, missing
+}
+
+// statsOf returns the statistics of the rows of ds at the provided indices
+func statsOf(ds *dataset.Dataset, statsEvaluator StatsEvaluator, rows []int) (SplitStats, error) {
+	stats, err := statsEvaluator.NewStats(ds)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		stats.Add(ds.Rows[r].Y(), ds.Weight(r))
+	}
+	return stats, nil
+}
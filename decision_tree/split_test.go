@@ -0,0 +1,442 @@
+package decision_tree
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"robertkotcher.me/ML2022/dataset"
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+// partitionOnly hides an evaluator's StatsEvaluator methods, so that splits are scored by
+// partitioning the dataset
+type partitionOnly struct {
+	Evaluator
+}
+
+func buildSplitDataset(classification bool) *dataset.Dataset {
+	rng := rand.New(rand.NewSource(1))
+	ds := dataset.NewDataset(
+		[]string{"a", "b", "color", "y"},
+		[]bool{true, true, false, !classification},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for i := 0; i < 60; i++ {
+		a := math.Floor(rng.Float64() * 10)
+		b := rng.Float64()
+		color := float64(rng.Intn(3))
+		y := a*2 + color + rng.Float64()
+		if classification {
+			y = math.Floor(y / 8)
+		}
+		ds.InsertWeightedRow(dataset.Row{a, b, color, y}, 1+float64(i%3))
+	}
+	return ds
+}
+
+func TestBestSplitMatchesPartitioning(t *testing.T) {
+	evaluators := map[string]Evaluator{
+		"regression":     RegressionEvaluator{},
+		"absolute error": RegressionEvaluator{Criterion: AbsoluteError},
+		"friedman":       RegressionEvaluator{Criterion: FriedmanMSE},
+		"poisson":        RegressionEvaluator{Criterion: PoissonDeviance},
+		"classification": ClassificationEvaluator{},
+		"entropy":        ClassificationEvaluator{Criterion: Entropy},
+		"gain ratio":     ClassificationEvaluator{Criterion: GainRatio},
+		"second order":   SecondOrderEvaluator{Lambda: 1},
+	}
+	for name, evaluator := range evaluators {
+		_, classification := evaluator.(ClassificationEvaluator)
+		ds := buildSplitDataset(classification)
+		for _, c := range []int{0, 1, 2} {
+			swept, err := bestSplitOnColumn(ds, evaluator, c, nil, BuildOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			partitioned, err := bestSplitOnColumn(ds, partitionOnly{evaluator}, c, nil, BuildOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swept.value != partitioned.value || math.Abs(swept.score-partitioned.score) > 1e-9 {
+				t.Errorf("%s column %d: expected split on %v scoring %v, got %v scoring %v",
+					name, c, partitioned.value, partitioned.score, swept.value, swept.score)
+			}
+		}
+	}
+}
+
+func TestHistogramSplits(t *testing.T) {
+	ds := buildSplitDataset(false)
+	evaluator := RegressionEvaluator{}
+
+	// with a bin per distinct value, binning shouldn't change the tree
+	exact, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	binned, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{MaxBins: ptr.PointToInt(ds.Size())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range ds.Rows {
+		a, _ := exact.Predict(row.X())
+		b, _ := binned.Predict(row.X())
+		if math.Abs(*a-*b) > 1e-9 {
+			t.Fatalf("expected binning with enough bins to predict %v, got %v", *a, *b)
+		}
+	}
+
+	// subtracting a sibling's histogram should give the same statistics as counting rows
+	bins, err := newBinning(ds, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bins.edges[1]) != 4 || bins.edges[2] != nil {
+		t.Fatalf("expected 4 bins for column b and none for color, got %v", bins.edges)
+	}
+	h, err := histogramOf(ds, bins, evaluator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition, err := ds.PartitionByName("a", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trueHistogram, falseHistogram, err := h.split(partition, evaluator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for side, expectedData := range map[*histogram]*dataset.Dataset{trueHistogram: partition.True, falseHistogram: partition.False} {
+		expected, err := histogramOf(expectedData, bins, evaluator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c := range expected.stats {
+			for b := range expected.stats[c] {
+				want := expected.stats[c][b].(*regressionStats)
+				got := side.stats[c][b].(*regressionStats)
+				wantSize, gotSize := expected.sizes[c][b], side.sizes[c][b]
+				if gotSize.count != wantSize.count || math.Abs(gotSize.weight-wantSize.weight) > 1e-9 || math.Abs(want.sum-got.sum) > 1e-9 {
+					t.Errorf("column %d bin %d: expected %v rows summing to %v, got %v summing to %v",
+						c, b, wantSize.count, want.sum, gotSize.count, got.sum)
+				}
+			}
+		}
+	}
+}
+
+func TestWorkersBuildTheSameTree(t *testing.T) {
+	ds := buildSplitDataset(true)
+	evaluator := ClassificationEvaluator{}
+
+	sequential, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 3, 8} {
+		parallel, err := BuildTreeWithOverfitting(ds, evaluator, BuildOptions{Workers: ptr.PointToInt(workers)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameSplits(sequential, parallel) {
+			t.Errorf("expected %d workers to build the same tree", workers)
+		}
+	}
+}
+
+// sameSplits returns true if both trees partition their rows the same way
+func sameSplits(a, b *DecisionNode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if (a.Partition == nil) != (b.Partition == nil) {
+		return false
+	}
+	if a.Partition != nil && (a.Partition.ColumnIndex != b.Partition.ColumnIndex || a.Partition.Value != b.Partition.Value) {
+		return false
+	}
+	return sameSplits(a.L, b.L) && sameSplits(a.R, b.R)
+}
+
+func TestMissingValues(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"x", "y"},
+		[]bool{true, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for x := 0.0; x < 10; x++ {
+		y := 0.0
+		if x >= 5 {
+			y = 10
+		}
+		ds.InsertRow(dataset.Row{x, y})
+	}
+	// rows without x look like the large ones
+	for i := 0; i < 4; i++ {
+		ds.InsertRow(dataset.Row{math.NaN(), 10})
+	}
+
+	for _, options := range []BuildOptions{{}, {MaxBins: ptr.PointToInt(4)}} {
+		tree, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Partition == nil || tree.Partition.Value != 4 || !tree.Partition.MissingGoesTrue {
+			t.Fatalf("expected the root to split on x > 4 and send missing values right, got %+v", tree.Partition)
+		}
+
+		pred, err := tree.Predict(dataset.Row{math.NaN()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *pred != 10 {
+			t.Errorf("expected a missing x to predict 10, got %v", *pred)
+		}
+	}
+}
+
+func TestCategorySubsetSplits(t *testing.T) {
+	evaluators := map[string]Evaluator{
+		"regression":     RegressionEvaluator{},
+		"friedman":       RegressionEvaluator{Criterion: FriedmanMSE},
+		"poisson":        RegressionEvaluator{Criterion: PoissonDeviance},
+		"classification": ClassificationEvaluator{},
+		"entropy":        ClassificationEvaluator{Criterion: Entropy},
+		"second order":   SecondOrderEvaluator{Lambda: 1},
+	}
+	for name, evaluator := range evaluators {
+		_, classification := evaluator.(ClassificationEvaluator)
+		ds := buildSplitDataset(classification)
+		if classification {
+			// subset splits are only searched for two classes
+			for _, row := range ds.Rows {
+				row[len(row)-1] = math.Min(row.Y(), 1)
+			}
+		}
+
+		subset, err := bestSplitOnColumn(ds, evaluator, 2, nil, BuildOptions{CategorySubsets: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// try every subset of the 3 colors that isn't empty or all of them
+		var best *float64
+		for mask := 1; mask < 7; mask++ {
+			categories := []float64{}
+			for color := 0; color < 3; color++ {
+				if mask&(1<<color) != 0 {
+					categories = append(categories, float64(color))
+				}
+			}
+			partition, err := ds.PartitionByCategories("color", categories, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			score, err := evaluator.EvaluateSplit(ds, partition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if best == nil || evaluator.IsBetter(*score, *best) {
+				best = score
+			}
+		}
+		if math.Abs(subset.score-*best) > 1e-9 {
+			t.Errorf("%s: expected the best subset split to score %v, got %v on %v", name, *best, subset.score, subset.categories)
+		}
+	}
+
+	// mean order can miss the best subset for these, so they still split one category from the rest
+	fallbacks := map[string]Evaluator{
+		"absolute error": RegressionEvaluator{Criterion: AbsoluteError},
+		"gain ratio":     ClassificationEvaluator{Criterion: GainRatio},
+	}
+	for name, evaluator := range fallbacks {
+		_, classification := evaluator.(ClassificationEvaluator)
+		ds := buildSplitDataset(classification)
+		if classification {
+			for _, row := range ds.Rows {
+				row[len(row)-1] = math.Min(row.Y(), 1)
+			}
+		}
+
+		subset, err := bestSplitOnColumn(ds, evaluator, 2, nil, BuildOptions{CategorySubsets: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		oneVsRest, err := bestSplitOnColumn(ds, evaluator, 2, nil, BuildOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subset.categories != nil || subset.value != oneVsRest.value || subset.score != oneVsRest.score {
+			t.Errorf("%s: expected to split color %v from the rest, got %v on %v", name, oneVsRest.value, subset.value, subset.categories)
+		}
+	}
+
+	// no single category separates the large targets from the small ones
+	ds := dataset.NewDataset(
+		[]string{"category", "y"},
+		[]bool{false, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	for i := 0; i < 30; i++ {
+		category := float64(i % 6)
+		y := 0.0
+		if category == 1 || category == 3 || category == 4 {
+			y = 10
+		}
+		ds.InsertRow(dataset.Row{category, y})
+	}
+	tree, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxDepth: ptr.PointToInt(2), CategorySubsets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Partition == nil || !reflect.DeepEqual(tree.Partition.Categories, []float64{1, 3, 4}) {
+		t.Fatalf("expected the root to split categories 1, 3 and 4 from the rest, got %+v", tree.Partition)
+	}
+	for category := 0.0; category < 6; category++ {
+		pred, err := tree.Predict(dataset.Row{category})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 0.0
+		if category == 1 || category == 3 || category == 4 {
+			expected = 10
+		}
+		if *pred != expected {
+			t.Errorf("expected category %v to predict %v, got %v", category, expected, *pred)
+		}
+	}
+}
+
+func TestLeafLimits(t *testing.T) {
+	ds := buildSplitDataset(false)
+	evaluators := map[string]Evaluator{
+		"regression":       RegressionEvaluator{},
+		"partitioning":     partitionOnly{RegressionEvaluator{}},
+		"second order":     SecondOrderEvaluator{Lambda: 1},
+		"classification":   ClassificationEvaluator{},
+		"histogram":        RegressionEvaluator{},
+		"category subsets": RegressionEvaluator{},
+	}
+	for name, evaluator := range evaluators {
+		ds := ds
+		if _, ok := evaluator.(ClassificationEvaluator); ok {
+			ds = buildSplitDataset(true)
+		}
+		options := BuildOptions{MinSamplesLeaf: ptr.PointToInt(5), MinWeightFractionLeaf: ptr.PointToFloat(.1)}
+		if name == "histogram" {
+			options.MaxBins = ptr.PointToInt(4)
+		}
+		options.CategorySubsets = name == "category subsets"
+
+		tree, err := BuildTreeWithOverfitting(ds, evaluator, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves, err := tree.getLeaves()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*leaves) < 2 {
+			t.Errorf("%s: expected the tree to split", name)
+		}
+		for _, leaf := range *leaves {
+			if leaf.TrainData.Size() < 5 || leaf.TrainData.TotalWeight() < .1*ds.TotalWeight()-1e-9 {
+				t.Errorf("%s: expected at least 5 rows and 10%% of the weight in each leaf, got %d rows weighing %v",
+					name, leaf.TrainData.Size(), leaf.TrainData.TotalWeight())
+			}
+		}
+	}
+
+	full, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noDecrease, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MinImpurityDecrease: ptr.PointToFloat(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSplits(full, noDecrease) {
+		t.Errorf("expected a minimum impurity decrease of 0 to build the same tree")
+	}
+	// no split lowers the mean squared error by more than the variance of the targets
+	stump, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MinImpurityDecrease: ptr.PointToFloat(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stump.Partition != nil {
+		t.Errorf("expected a large minimum impurity decrease to stop at the root, got %+v", stump.Partition)
+	}
+
+	// a minimum between the decrease of the root's split and those of its children's splits keeps
+	// only the root's
+	decrease := func(node *DecisionNode) float64 {
+		d, err := impurityDecrease(RegressionEvaluator{}, node.TrainData, node.Partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d / ds.TotalWeight()
+	}
+	rootDecrease, childDecrease := decrease(full), math.Max(decrease(full.L), decrease(full.R))
+	if childDecrease >= rootDecrease {
+		t.Fatalf("expected the root's split to lower impurity more than its children's, got %v and %v", rootDecrease, childDecrease)
+	}
+	between := (rootDecrease + childDecrease) / 2
+	rootOnly, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MinImpurityDecrease: &between})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSplits(rootOnly, &DecisionNode{Partition: full.Partition, L: &DecisionNode{}, R: &DecisionNode{}}) {
+		t.Errorf("expected a minimum impurity decrease of %v to keep only the root's split", between)
+	}
+
+	allFeatures, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxFeatures: ptr.PointToInt(3), Rand: rand.New(rand.NewSource(1))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSplits(full, allFeatures) {
+		t.Errorf("expected searching every feature to build the same tree")
+	}
+
+	// each node searches the one column that it samples, which replaying the Rand (in the order
+	// that nodes are built) draws again
+	oneFeature, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxFeatures: ptr.PointToInt(1), Rand: rand.New(rand.NewSource(1))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := BuildOptions{MaxFeatures: ptr.PointToInt(1), Rand: rand.New(rand.NewSource(1))}
+	var checkColumns func(node *DecisionNode)
+	checkColumns = func(node *DecisionNode) {
+		columns, err := replay.candidateColumns(node.TrainData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(columns) != 1 {
+			t.Fatalf("expected to sample 1 column, got %v", columns)
+		}
+		if node.Partition == nil {
+			return
+		}
+		best, err := bestSplitOnColumn(node.TrainData, RegressionEvaluator{}, columns[0], nil, BuildOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Partition.ColumnIndex != columns[0] || node.Partition.Value != best.value {
+			t.Errorf("expected the best split on sampled column %d, got %+v", columns[0], node.Partition)
+		}
+		checkColumns(node.R)
+		checkColumns(node.L)
+	}
+	checkColumns(oneFeature)
+	if sameSplits(full, oneFeature) {
+		t.Errorf("expected searching one feature at a time to build a different tree")
+	}
+	if _, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxFeatures: ptr.PointToInt(1)}); err == nil {
+		t.Errorf("expected an error for sampling features without Rand")
+	}
+}
@@ -0,0 +1,216 @@
+package decision_tree
+
+import (
+	"math"
+	"sort"
+
+	"robertkotcher.me/ML2022/dataset"
+)
+
+// Surrogate is a backup split for a node, on a different column than its primary Partition, that
+// sends rows the same way as the primary split as often as possible. Predict uses a node's
+// surrogates, best first, when a row is missing the primary column.
+type Surrogate struct {
+	// Partition holds the column and value of the surrogate split. Its False and True datasets are
+	// nil.
+	Partition *dataset.Partition
+	// Reversed surrogates send rows that Partition evaluates as true to the primary split's false
+	// side, and the other way around
+	Reversed bool
+	// Agreement is the (weighted) fraction of training rows with both columns that the surrogate
+	// sends the same way as the primary split. AdjustedAgreement is how much better that is than
+	// sending every row to the primary split's larger side, where 1 means a perfect match and 0
+	// means no better.
+	Agreement         float64
+	AdjustedAgreement float64
+}
+
+// EvaluateRow returns the side of the primary split that the surrogate sends r to
+func (s Surrogate) EvaluateRow(r dataset.Row) bool {
+	return s.Partition.EvaluateRow(r) != s.Reversed
+}
+
+// evaluateRow returns true if n sends r to its true (right) side. Rows missing the primary column
+// follow the first surrogate whose column they have, or the primary split's default direction.
+func (n *DecisionNode) evaluateRow(r dataset.Row) bool {
+	if !n.Partition.IsMissing(r) {
+		return n.Partition.EvaluateRow(r)
+	}
+	for _, s := range n.Surrogates {
+		if !s.Partition.IsMissing(r) {
+			return s.EvaluateRow(r)
+		}
+	}
+	return n.Partition.MissingGoesTrue
+}
+
+// partitionBySurrogates returns n's partition of ds, with rows missing the primary column sent
+// where evaluateRow sends them
+func (n *DecisionNode) partitionBySurrogates(ds *dataset.Dataset) *dataset.Partition {
+	p := *n.Partition
+	p.False = dataset.NewDataset(ds.ColumnNames, ds.ColumnIsContinuous, []dataset.Row{}, ds.EnumMapper)
+	p.True = dataset.NewDataset(ds.ColumnNames, ds.ColumnIsContinuous, []dataset.Row{}, ds.EnumMapper)
+	for r, row := range ds.Rows {
+		if n.evaluateRow(row) {
+			p.True.InsertWeightedRow(row, ds.Weight(r))
+		} else {
+			p.False.InsertWeightedRow(row, ds.Weight(r))
+		}
+	}
+	return &p
+}
+
+// findSurrogates returns up to maxSurrogates surrogates for the primary partition of ds, ranked by
+// agreement. Only surrogates that do better than the primary split's larger side are kept.
+func findSurrogates(ds *dataset.Dataset, primary *dataset.Partition, maxSurrogates int) []Surrogate {
+	surrogates := []Surrogate{}
+	for c := 0; c < len(ds.ColumnNames)-1; c++ {
+		if c == primary.ColumnIndex {
+			continue
+		}
+		if s := bestSurrogateOnColumn(ds, primary, c); s != nil && s.AdjustedAgreement > 0 {
+			surrogates = append(surrogates, *s)
+		}
+	}
+
+	sort.SliceStable(surrogates, func(i, j int) bool {
+		return surrogates[i].Agreement > surrogates[j].Agreement
+	})
+	if len(surrogates) > maxSurrogates {
+		surrogates = surrogates[:maxSurrogates]
+	}
+	return surrogates
+}
+
+// bestSurrogateOnColumn returns the split on column c that best agrees with the primary partition,
+// over the rows of ds that have both columns. It's nil if there are no such rows.
+func bestSurrogateOnColumn(ds *dataset.Dataset, primary *dataset.Partition, c int) *Surrogate {
+	rows := []int{}
+	totalWeight, trueWeight := 0.0, 0.0
+	for r, row := range ds.Rows {
+		if primary.IsMissing(row) || math.IsNaN(row[c]) {
+			continue
+		}
+		rows = append(rows, r)
+		totalWeight += ds.Weight(r)
+		if primary.EvaluateRow(row) {
+			trueWeight += ds.Weight(r)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// agreeing is the weight of rows that a candidate surrogate sends the same way as the primary
+	// split. a reversed candidate agrees on every other row.
+	var best *Surrogate
+	consider := func(value, agreeing float64) {
+		reversed := false
+		if totalWeight-agreeing > agreeing {
+			reversed, agreeing = true, totalWeight-agreeing
+		}
+		if best == nil || agreeing/totalWeight > best.Agreement {
+			best = &Surrogate{
+				Partition: &dataset.Partition{
+					ColumnIndex:  c,
+					ColumnName:   ds.ColumnNames[c],
+					IsContinuous: ds.ColumnIsContinuous[c],
+					Value:        value,
+				},
+				Reversed:  reversed,
+				Agreement: agreeing / totalWeight,
+			}
+		}
+	}
+
+	if ds.ColumnIsContinuous[c] {
+		// sweep thresholds like sweepSplitsOnColumn. with every row on the true side, the rows that
+		// agree are the primary split's true rows
+		sort.SliceStable(rows, func(i, j int) bool {
+			return ds.Rows[rows[i]][c] < ds.Rows[rows[j]][c]
+		})
+		agreeing := trueWeight
+		for i := 0; i < len(rows); {
+			value := ds.Rows[rows[i]][c]
+			for ; i < len(rows) && ds.Rows[rows[i]][c] == value; i++ {
+				r := rows[i]
+				if primary.EvaluateRow(ds.Rows[r]) {
+					agreeing -= ds.Weight(r)
+				} else {
+					agreeing += ds.Weight(r)
+				}
+			}
+			consider(value, agreeing)
+		}
+	} else {
+		// sending a category to the true side, and everything else to the false side, agrees with
+		// the primary split's false rows plus the category's true rows, less its false rows
+		categoryBalance := map[float64]float64{}
+		values := []float64{}
+		for _, r := range rows {
+			value := ds.Rows[r][c]
+			if _, ok := categoryBalance[value]; !ok {
+				values = append(values, value)
+			}
+			if primary.EvaluateRow(ds.Rows[r]) {
+				categoryBalance[value] += ds.Weight(r)
+			} else {
+				categoryBalance[value] -= ds.Weight(r)
+			}
+		}
+		sort.Float64s(values)
+		for _, value := range values {
+			consider(value, totalWeight-trueWeight+categoryBalance[value])
+		}
+	}
+
+	majority := math.Max(trueWeight, totalWeight-trueWeight) / totalWeight
+	if majority < 1 {
+		best.AdjustedAgreement = (best.Agreement - majority) / (1 - majority)
+	}
+	return best
+}
+
+// VariableImportance returns how much each feature column contributes to the tree starting at n,
+// keyed by column name. Like CART, a column gets the improvement of every split it's the primary
+// column of, plus the improvement times the adjusted agreement of every split it's a surrogate for.
+// A split's improvement is how much it lowers the risk that cost-complexity pruning uses.
+func (n *DecisionNode) VariableImportance() (map[string]float64, error) {
+	importance := map[string]float64{}
+	err := n.addVariableImportance(n, importance)
+	if err != nil {
+		return nil, err
+	}
+	return importance, nil
+}
+
+// addVariableImportance adds the importance of the splits in the subtree at n to importance
+func (n *DecisionNode) addVariableImportance(root *DecisionNode, importance map[string]float64) error {
+	if n.Partition == nil || n.L == nil || n.R == nil {
+		return nil
+	}
+
+	risk, err := n.risk(root)
+	if err != nil {
+		return err
+	}
+	lRisk, err := n.L.risk(root)
+	if err != nil {
+		return err
+	}
+	rRisk, err := n.R.risk(root)
+	if err != nil {
+		return err
+	}
+
+	improvement := risk - lRisk - rRisk
+	importance[n.Partition.ColumnName] += improvement
+	for _, s := range n.Surrogates {
+		importance[s.Partition.ColumnName] += improvement * s.AdjustedAgreement
+	}
+
+	if err := n.L.addVariableImportance(root, importance); err != nil {
+		return err
+	}
+	return n.R.addVariableImportance(root, importance)
+}
@@ -0,0 +1,64 @@
+package decision_tree
+
+import (
+	"math"
+	"testing"
+
+	"robertkotcher.me/ML2022/dataset"
+	ptr "robertkotcher.me/ML2022/util"
+)
+
+func TestSurrogates(t *testing.T) {
+	ds := dataset.NewDataset(
+		[]string{"size", "rooms", "noise", "price"},
+		[]bool{true, true, true, true},
+		[]dataset.Row{},
+		&dataset.EnumMapper{},
+	)
+	// rooms almost always follows size, and noise doesn't
+	for i := 0.0; i < 20; i++ {
+		rooms := math.Floor(i / 5)
+		if i == 9 {
+			rooms = 3
+		}
+		price := 100.0
+		if i >= 10 {
+			price = 200
+		}
+		ds.InsertRow(dataset.Row{i, rooms, math.Mod(i*7, 3), price})
+	}
+	// most rows missing size are small, so the default direction is left
+	ds.InsertRow(dataset.Row{math.NaN(), 0, 0, 100})
+	ds.InsertRow(dataset.Row{math.NaN(), 1, 0, 100})
+
+	tree, err := BuildTreeWithOverfitting(ds, RegressionEvaluator{}, BuildOptions{MaxDepth: ptr.PointToInt(2), MaxSurrogates: ptr.PointToInt(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Partition == nil || tree.Partition.ColumnName != "size" {
+		t.Fatalf("expected the root to split on size, got %+v", tree.Partition)
+	}
+	if len(tree.Surrogates) == 0 || tree.Surrogates[0].Partition.ColumnName != "rooms" {
+		t.Fatalf("expected rooms to be the best surrogate, got %+v", tree.Surrogates)
+	}
+	if tree.Surrogates[0].Agreement != 0.95 {
+		t.Errorf("expected rooms to agree with size on 19 of 20 rows, got %v", tree.Surrogates[0].Agreement)
+	}
+
+	// a large house with no size should follow rooms rather than the default direction
+	pred, err := tree.Predict(dataset.Row{math.NaN(), 3, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *pred != 200 {
+		t.Errorf("expected the surrogate to predict 200, got %v", *pred)
+	}
+
+	importance, err := tree.VariableImportance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if importance["size"] <= importance["rooms"] || importance["rooms"] <= 0 {
+		t.Errorf("expected size to matter most, then rooms, got %v", importance)
+	}
+}
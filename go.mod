@@ -4,7 +4,7 @@ go 1.16
 
 require (
 	github.com/disiqueira/gotree v1.0.0 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
+	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/sys v0.0.0-20210304124612-50617c2ba197 // indirect
-	gonum.org/v1/plot v0.10.0 // indirect
+	gonum.org/v1/plot v0.10.0
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/sirupsen/logrus"
+	"robertkotcher.me/ML2022/boosting"
 	"robertkotcher.me/ML2022/dataset"
 	"robertkotcher.me/ML2022/decision_tree"
 	ptr "robertkotcher.me/ML2022/util"
@@ -50,22 +51,15 @@ func buildTitanicDecisionTree() {
 		MinSamplesForSplit: ptr.PointToInt(10),
 	}
 
-	tree, _ := decision_tree.BuildTreeWithOverfitting(ds, evaluator, options)
-	trees, alphas, err := tree.GetSubtreesAndAlphas()
-	if err != nil {
-		logrus.Error(err)
-	}
-
-	idx, err := decision_tree.GetAlphaIndexFromCrossValidation(*alphas, ds, evaluator, options)
+	fit, err := decision_tree.Fit(ds, evaluator, options)
 	if err != nil {
-		logrus.Error(err)
+		logrus.Fatal(err)
 	}
 
-	logrus.Infof("tree with alpha %f", (*alphas)[len(*trees)-1])
-	winner := (*trees)[*idx]
-	winner.Print()
+	logrus.Infof("tree with alpha %f", fit.Alpha)
+	fit.Tree.Print()
 
-	for i, t := range *trees {
+	for i, t := range fit.Subtrees {
 		totres := 0.0
 		for _, r := range ds.Rows {
 			o, _ := t.Predict(r.X())
@@ -73,7 +67,7 @@ func buildTitanicDecisionTree() {
 				totres += 1.0
 			}
 		}
-		logrus.Infof("alpha %v, num misclassified %v", (*alphas)[i], totres)
+		logrus.Infof("alpha %v, num misclassified %v, cv error %v (± %v)", fit.Alphas[i], totres, fit.CVScores[i], fit.CVStandardErrors[i])
 	}
 }
 
@@ -113,38 +107,115 @@ func buildBostonDecisionTree() {
 		MinSamplesForSplit: ptr.PointToInt(10),
 	}
 
-	tree, _ := decision_tree.BuildTreeWithOverfitting(ds, evaluator, options)
-	trees, alphas, err := tree.GetSubtreesAndAlphas()
-	logrus.Warn(err)
-
-	idx, err := decision_tree.GetAlphaIndexFromCrossValidation(*alphas, ds, evaluator, options)
+	fit, err := decision_tree.Fit(ds, evaluator, options)
 	if err != nil {
-		logrus.Error(err)
+		logrus.Fatal(err)
 	}
 
-	logrus.Infof("tree with alpha %f", (*alphas)[len(*trees)-1])
-	winner := (*trees)[*idx]
-	winner.Print()
+	logrus.Infof("tree with alpha %f", fit.Alpha)
+	fit.Tree.Print()
 
 	// print key
 	logrus.Info("Enum mapper:")
 	logrus.Info(*ds.EnumMapper)
 
-	// for i, t := range *trees {
+	// for i, t := range fit.Subtrees {
 	// 	totres := 0.0
 	// 	for _, r := range ds.Rows {
 	// 		o, _ := t.Predict(r.X())
 	// 		totres += ((*o) - r.Y()) * ((*o) - r.Y())
 	// 	}
 	// 	totres = totres / float64(ds.Size())
-	// 	logrus.Infof("alpha %v, avg residual %v", (*alphas)[i], totres)
+	// 	logrus.Infof("alpha %v, avg residual %v", fit.Alphas[i], totres)
 	// }
+}
+
+func buildBostonBoostingModel() {
+	colData := dataset.ColumnsToInclude{
+		"crim":  true,
+		"zn":    true,
+		"indus": true,
+		"rm":    true,
+		"age":   true,
+		"medv":  true,
+	}
 
-	decision_tree.GetAlphaIndexFromCrossValidation(*alphas, ds, evaluator, options)
+	ds, err := dataset.BuildDatasetFromCSV("BostonHousing.csv", colData, "medv")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	options := boosting.BuildOptions{
+		LearningRate:  0.1,
+		NumIterations: 50,
+		TreeOptions: decision_tree.BuildOptions{
+			MaxDepth:           ptr.PointToInt(3),
+			MinSamplesForSplit: ptr.PointToInt(10),
+		},
+	}
+
+	model, err := boosting.BuildGradiantBoostingModel(ds, decision_tree.RegressionEvaluator{}, options)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	totres := 0.0
+	for _, r := range ds.Rows {
+		o, err := model.Predict(r.X())
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		totres += ((*o) - r.Y()) * ((*o) - r.Y())
+	}
+	logrus.Infof("boosting with %d successors, avg residual %v", len(*model.Successors), totres/float64(ds.Size()))
+}
+
+func buildTitanicBoostingModel() {
+	colData := dataset.ColumnsToInclude{
+		"Pclass":   true,
+		"Sex":      false,
+		"Age":      true,
+		"Survived": false,
+	}
+	ds, err := dataset.BuildDatasetFromCSV("TitanicTrain.csv", colData, "Survived")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.Info("Enum mapper:")
+	logrus.Info(*ds.EnumMapper)
+
+	options := boosting.BuildOptions{
+		LearningRate:  0.1,
+		NumIterations: 50,
+		TreeOptions: decision_tree.BuildOptions{
+			MaxDepth:           ptr.PointToInt(3),
+			MinSamplesForSplit: ptr.PointToInt(10),
+		},
+	}
+
+	model, err := boosting.BuildGradiantBoostingModel(ds, decision_tree.ClassificationEvaluator{}, options)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	totres := 0.0
+	for _, r := range ds.Rows {
+		o, err := model.PredictClass(r.X())
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if *o != r.Y() {
+			totres += 1.0
+		}
+	}
+	logrus.Infof("boosting with %d successors, num misclassified %v", len(*model.Successors), totres)
 }
 
 func main() {
 	runPresentation0()
 	// buildBostonDecisionTree()
+	// buildBostonBoostingModel()
+	// buildTitanicBoostingModel()
 	// buildTitanicDecisionTree()
 }
@@ -3,3 +3,7 @@ package ptr
 func PointToInt(i int) *int {
 	return &i
 }
+
+func PointToFloat(f float64) *float64 {
+	return &f
+}